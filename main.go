@@ -30,4 +30,40 @@ func InitTimer() {
 		wrapCleanConn,
 		"",
 	)
+	//定时消息扫描
+	utils.Timer(
+		time.Duration(viper.GetInt("schedule.DelayScan"))*time.Second,
+		time.Duration(viper.GetInt("schedule.ScanHz"))*time.Second,
+		func(param interface{}) bool {
+			return models.RunScheduledMsgs()
+		},
+		"",
+	)
+	//阅后即焚消息清理
+	utils.Timer(
+		time.Duration(viper.GetInt("schedule.DelayScan"))*time.Second,
+		time.Duration(viper.GetInt("schedule.ExpireHz"))*time.Second,
+		func(param interface{}) bool {
+			return models.PurgeExpiredMsgs()
+		},
+		"",
+	)
+	//执行到期的账号注销
+	utils.Timer(
+		time.Duration(viper.GetInt("schedule.DelayScan"))*time.Second,
+		time.Duration(viper.GetInt("schedule.DeletionHz"))*time.Second,
+		func(param interface{}) bool {
+			return models.RunAccountDeletions()
+		},
+		"",
+	)
+	//消息冷热分层整理
+	utils.Timer(
+		time.Duration(viper.GetInt("schedule.DelayScan"))*time.Second,
+		time.Duration(viper.GetInt("retention.CompactHz"))*time.Second,
+		func(param interface{}) bool {
+			return models.CompactMessages()
+		},
+		"",
+	)
 }
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// 注销申请状态
+const (
+	DeletionPending   = 0 //冷静期中
+	DeletionCancelled = 1 //已撤销
+	DeletionDone      = 2 //已执行
+)
+
+// AccountDeletion 账号注销申请  冷静期结束后才真正执行
+type AccountDeletion struct {
+	gorm.Model
+	UserId    uint
+	ExecuteAt time.Time //冷静期结束时间
+	Status    int       //0冷静期中 1已撤销 2已执行
+}
+
+func (table *AccountDeletion) TableName() string {
+	return "account_deletion"
+}
+
+// RequestAccountDeletion 申请注销账号  重复申请返回已有的申请
+func RequestAccountDeletion(userId uint) (AccountDeletion, int, string) {
+	deletion := AccountDeletion{}
+	if FindByID(userId).ID == 0 {
+		return deletion, -1, "用户不存在"
+	}
+	utils.DB.Where("user_id = ? and status = ?", userId, DeletionPending).Find(&deletion)
+	if deletion.ID != 0 {
+		return deletion, 0, "已申请注销"
+	}
+	graceDays := viper.GetInt("account.DeleteGraceDays")
+	deletion = AccountDeletion{
+		UserId:    userId,
+		ExecuteAt: time.Now().Add(time.Duration(graceDays) * 24 * time.Hour),
+		Status:    DeletionPending,
+	}
+	if err := utils.DB.Create(&deletion).Error; err != nil {
+		return deletion, -1, "申请注销失败"
+	}
+	return deletion, 0, "申请注销成功 " + strconv.Itoa(graceDays) + " 天内可撤销"
+}
+
+// CancelAccountDeletion 冷静期内撤销注销
+func CancelAccountDeletion(userId uint) (int, string) {
+	res := utils.DB.Model(&AccountDeletion{}).
+		Where("user_id = ? and status = ?", userId, DeletionPending).
+		Update("status", DeletionCancelled)
+	if res.RowsAffected == 0 {
+		return -1, "没有待执行的注销申请"
+	}
+	return 0, "已撤销注销"
+}
+
+// RunAccountDeletions 执行冷静期已结束的注销申请
+// 通过推后执行时间的 UPDATE 抢占 多节点下同一时间只有一个节点执行
+// 全部完成后才标记为已执行 失败或节点崩溃时租约过后重试
+func RunAccountDeletions() bool {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("run account deletions err", r)
+		}
+	}()
+	deletions := make([]AccountDeletion, 0)
+	utils.DB.Where("status = ? and execute_at <= ?", DeletionPending, time.Now()).Limit(20).Find(&deletions)
+	for _, v := range deletions {
+		res := utils.DB.Model(&AccountDeletion{}).
+			Where("id = ? and status = ? and execute_at = ?", v.ID, DeletionPending, v.ExecuteAt).
+			Update("execute_at", time.Now().Add(10*time.Minute))
+		if res.RowsAffected == 0 {
+			continue
+		}
+		if err := deleteAccount(v.UserId); err != nil {
+			fmt.Println("delete account fail: ", v.UserId, err)
+			continue
+		}
+		utils.DB.Model(&AccountDeletion{}).Where("id = ?", v.ID).Update("status", DeletionDone)
+	}
+	return true
+}
+
+// deleteAccount 注销账号  可重复执行
+// 消息在会话中保留占位 但内容和附件删除 发送者资料匿名化
+// 双向删除好友关系 转让或解散自己创建的群 吊销登录态 删除上传的文件
+func deleteAccount(userId uint) error {
+	user := FindByID(userId)
+	if user.ID == 0 {
+		// 上次已执行到最后一步 只差标记完成
+		var deleted int64
+		utils.DB.Unscoped().Model(&UserBasic{}).Where("id = ? and deleted_at is not null", userId).Count(&deleted)
+		if deleted > 0 {
+			return nil
+		}
+		return fmt.Errorf("user %d not found", userId)
+	}
+
+	// 1. 消息内容和附件  MySQL和缓存中的副本都清掉
+	msgs := make([]Message, 0)
+	utils.DB.Unscoped().Where("user_id = ? and (url <> '' or pic <> '')", userId).Find(&msgs)
+	for _, v := range msgs {
+		removeUpload(v.Url)
+		removeUpload(v.Pic)
+	}
+	// 已归档的消息 先从归档文件中删掉该用户的原文 再清空归档标记 避免 AfterFind 还原出原内容
+	archives := make([]string, 0)
+	utils.DB.Unscoped().Model(&Message{}).Where("user_id = ? and archive <> ''", userId).Distinct().Pluck("archive", &archives)
+	for _, name := range archives {
+		if err := dropArchiveUser(name, userId); err != nil {
+			return err
+		}
+	}
+	err := utils.DB.Unscoped().Model(&Message{}).Where("user_id = ?", userId).
+		Updates(map[string]interface{}{"content": deletedContent, "url": "", "pic": "", "desc": "", "quote": "", "archive": ""}).Error
+	if err != nil {
+		return err
+	}
+	convs := make([]Message, 0)
+	utils.DB.Unscoped().Model(&Message{}).Select("distinct user_id, target_id, type, thread_id").
+		Where("user_id = ? and type in (1, 2)", userId).Find(&convs)
+	for _, v := range convs {
+		scrubCachedMsgs(convKey(v), userId)
+	}
+
+	// 2. 群  有其他成员的转让给最早入群的成员 否则解散
+	communities := make([]GroupBasic, 0)
+	utils.DB.Where("owner_id = ?", userId).Find(&communities)
+	for _, v := range communities {
+		heir := GroupMember{}
+		utils.DB.Where("group_id = ? and user_id <> ?", v.ID, userId).Order("role desc, joined_at").Limit(1).Find(&heir)
+		if heir.ID != 0 {
+			utils.DB.Transaction(func(tx *gorm.DB) error {
+				return transferOwner(tx, v.ID, 0, heir.UserId)
+			})
+		} else {
+			utils.DB.Unscoped().Where("group_id = ?", v.ID).Delete(&GroupMember{})
+			invalidateGroupMembers(v.ID)
+			utils.DB.Delete(&v)
+		}
+	}
+
+	// 3. 好友与群关系 双向删除
+	groupIds := make([]uint, 0)
+	utils.DB.Model(&GroupMember{}).Where("user_id = ?", userId).Pluck("group_id", &groupIds)
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("owner_id = ?", userId).Delete(&Contact{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("target_id = ? and type = 1", userId).Delete(&Contact{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userId).Delete(&GroupMember{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range groupIds {
+		invalidateGroupMembers(v)
+	}
+
+	// 4. 个人数据
+	utils.DB.Unscoped().Where("user_id = ?", userId).Delete(&MessageHidden{})
+	utils.DB.Where("user_id = ?", userId).Delete(&StarredMessage{})
+	utils.DB.Where("user_id = ?", userId).Delete(&Mention{})
+	utils.DB.Where("from_id = ? or to_id = ?", userId, userId).Delete(&FriendRequest{})
+	utils.DB.Where("user_id = ? or blocked_id = ?", userId, userId).Delete(&UserBlock{})
+	utils.DB.Where("user_id = ?", userId).Delete(&PrivacySetting{})
+	utils.DB.Unscoped().Where("owner_id = ? or target_id = ?", userId, userId).Delete(&ContactTag{})
+	for _, v := range LoadScheduledMsgs(userId) {
+		CancelScheduledMsg(userId, v.ID)
+	}
+	removeUserExports(userId)
+
+	// 5. 登录态与在线状态
+	if user.Identity != "" {
+		if err := utils.InvalidateToken(user.Identity); err != nil {
+			fmt.Println(err)
+		}
+	}
+	utils.RedisCluster.Del(context.Background(), "online_"+strconv.Itoa(int(userId)))
+	rwLocker.RLock()
+	node, ok := clientMap[int64(userId)]
+	rwLocker.RUnlock()
+	if ok {
+		node.Conn.Close()
+	}
+
+	// 6. 头像与资料匿名化后软删除
+	removeUpload(user.Avatar)
+	utils.DB.Model(&user).Updates(map[string]interface{}{
+		"name":      "已注销用户" + strconv.Itoa(int(userId)),
+		"pass_word": "",
+		"salt":      "",
+		"phone":     "",
+		"email":     "",
+		"avatar":    "",
+		"identity":  "",
+		"client_ip": "",
+	})
+	return utils.DB.Delete(&user).Error
+}
+
+// deletedContent 注销用户消息的占位内容
+const deletedContent = "[该用户已注销]"
+
+// scrubCachedMsgs 把缓存中该用户发送的消息换成清空内容后的版本  分数不变
+func scrubCachedMsgs(key string, userId uint) {
+	ctx := context.Background()
+	zs, err := utils.RedisCluster.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, z := range zs {
+		member, _ := z.Member.(string)
+		cached := Message{}
+		if json.Unmarshal([]byte(member), &cached) != nil || cached.UserId != int64(userId) {
+			continue
+		}
+		cached.Content = deletedContent
+		cached.Url = ""
+		cached.Pic = ""
+		cached.Desc = ""
+		cached.Quote = ""
+		utils.RedisCluster.ZRem(ctx, key, member)
+		utils.RedisCluster.ZAdd(ctx, key, redis.Z{Score: z.Score, Member: cached})
+	}
+}
+
+// UserDataExport 用户的全部个人数据
+type UserDataExport struct {
+	Profile    UserBasic
+	Contacts   []Contact
+	Groups     []GroupBasic //加入的群
+	Members    []GroupMember
+	Messages   []Message //发送和收到的私聊消息 以及自己发送的群消息
+	Hidden     []MessageHidden
+	Starred    []StarredMessage
+	Mentions   []Mention
+	Requests   []FriendRequest //发出和收到的好友申请
+	Blocked    []UserBlock
+	Tags       []ContactTag
+	Privacy    PrivacySetting
+	Scheduled  []ScheduledMsg
+	Deletion   []AccountDeletion
+	ExportedAt time.Time
+}
+
+// ExportUserData 导出与用户相关的全部数据  只能用本人的登录token导出
+func ExportUserData(userId uint, token string) (UserDataExport, int, string) {
+	data := UserDataExport{ExportedAt: time.Now()}
+	info, err := utils.VerifyAccessToken(token)
+	if err != nil || info.UserID != userId {
+		return data, -1, "身份验证失败"
+	}
+	data.Profile = FindByID(userId)
+	if data.Profile.ID == 0 {
+		return data, -1, "用户不存在"
+	}
+	data.Profile.PassWord = ""
+	data.Profile.Salt = ""
+	data.Profile.Identity = ""
+	utils.DB.Where("owner_id = ?", userId).Find(&data.Contacts)
+	utils.DB.Where("user_id = ?", userId).Find(&data.Members)
+	groupIds := make([]uint, 0)
+	for _, v := range data.Members {
+		groupIds = append(groupIds, v.GroupId)
+	}
+	utils.DB.Where("id in ?", groupIds).Find(&data.Groups)
+	utils.DB.Where("user_id = ? or (type = 1 and target_id = ?)", userId, userId).Order("id").Find(&data.Messages)
+	utils.DB.Where("user_id = ?", userId).Find(&data.Hidden)
+	utils.DB.Where("user_id = ?", userId).Find(&data.Starred)
+	utils.DB.Where("user_id = ?", userId).Find(&data.Mentions)
+	utils.DB.Where("user_id = ?", userId).Find(&data.Deletion)
+	utils.DB.Where("from_id = ? or to_id = ?", userId, userId).Find(&data.Requests)
+	utils.DB.Where("user_id = ?", userId).Find(&data.Blocked)
+	data.Privacy = FindPrivacy(userId)
+	utils.DB.Where("owner_id = ?", userId).Find(&data.Tags)
+	data.Scheduled = LoadScheduledMsgs(userId)
+	return data, 0, "ok"
+}
@@ -0,0 +1,203 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChannelInfo 订阅的频道 附带未读数和订阅人数
+type ChannelInfo struct {
+	*GroupBasic
+	LastSeq     int64 //频道最新序号
+	Unread      int64 //未读数
+	Subscribers int64 //订阅人数
+}
+
+// ChannelPost 频道里的一条消息 附带阅读数和评论数
+type ChannelPost struct {
+	Message
+	Views    int64 //阅读人数
+	ThreadId uint  `json:"CommentThreadId"` //评论话题 0表示还没有评论
+	Comments int64 //评论数
+}
+
+// channelReadKey 用户在各频道中已读到的序号
+func channelReadKey(userId uint) string {
+	return "channel_read_" + strconv.Itoa(int(userId))
+}
+
+func channelViewKey(msgId uint) string {
+	return "channel_view_" + strconv.Itoa(int(msgId))
+}
+
+// findChannel 查找频道  不是频道时ID为0
+func findChannel(channelId uint) GroupBasic {
+	channel := findCommunity(channelId)
+	if channel.Type != GroupChannel {
+		return GroupBasic{}
+	}
+	return channel
+}
+
+// Subscribe 订阅频道  与入群相同 但不在频道里发系统消息
+func Subscribe(userId uint, channelId uint) (int, string) {
+	channel := findChannel(channelId)
+	if channel.ID == 0 {
+		return -1, "没有找到频道"
+	}
+	if IsGroupMember(userId, channel.ID) {
+		return -1, "已订阅此频道"
+	}
+	switch channel.JoinPolicy {
+	case JoinInvite:
+		return -1, "该频道只能通过邀请订阅"
+	case JoinApproval:
+		return submitJoinRequest(channel.ID, userId, 0, "")
+	}
+	code, msg := addToGroup(channel.ID, userId, "")
+	if code == 0 {
+		msg = "订阅成功"
+	}
+	return code, msg
+}
+
+// Unsubscribe 取消订阅
+func Unsubscribe(userId uint, channelId uint) (int, string) {
+	if findChannel(channelId).ID == 0 {
+		return -1, "没有找到频道"
+	}
+	code, msg := LeaveGroup(userId, channelId)
+	if code == 0 {
+		utils.RedisCluster.HDel(context.Background(), channelReadKey(userId), strconv.Itoa(int(channelId)))
+	}
+	return code, msg
+}
+
+// LoadChannels 订阅的频道列表
+func LoadChannels(userId uint) []ChannelInfo {
+	members := make([]GroupMember, 0)
+	utils.DB.Where("user_id = ? and group_id in (?)", userId,
+		utils.DB.Model(&GroupBasic{}).Select("id").Where("type = ?", GroupChannel)).Find(&members)
+	ctx := context.Background()
+	readKey := channelReadKey(userId)
+	res := make([]ChannelInfo, 0, len(members))
+	for _, v := range members {
+		channel := findCommunity(v.GroupId)
+		info := ChannelInfo{GroupBasic: &channel}
+		info.LastSeq, _ = utils.RedisCluster.Get(ctx, "seq_"+groupKey(v.GroupId)).Int64()
+		readSeq, err := utils.RedisCluster.HGet(ctx, readKey, strconv.Itoa(int(v.GroupId))).Int64()
+		if err != nil {
+			readSeq = v.JoinSeq
+		}
+		if info.Unread = info.LastSeq - readSeq; info.Unread < 0 {
+			info.Unread = 0
+		}
+		utils.DB.Model(&GroupMember{}).Where("group_id = ?", v.GroupId).Count(&info.Subscribers)
+		res = append(res, info)
+	}
+	return res
+}
+
+// ChannelPosts 拉取频道中序号大于 afterSeq 的消息  发布时不给离线订阅者写任何数据 由订阅者自己拉取
+// 拉取到的消息计入阅读数 并把频道标记为读到最后一条
+func ChannelPosts(userId uint, channelId uint, afterSeq int64, limit int64) ([]ChannelPost, int, string) {
+	if findChannel(channelId).ID == 0 {
+		return nil, -1, "没有找到频道"
+	}
+	floor, ok := groupHistoryFloor(userId, channelId)
+	if !ok {
+		return nil, -1, "没有订阅此频道"
+	}
+	// 频道隐藏历史时 只能拉到订阅之后的消息
+	if afterSeq < floor {
+		afterSeq = floor
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	ctx := context.Background()
+	key := groupKey(channelId)
+	var rels []string
+	oldest, err := utils.RedisCluster.ZRangeWithScores(ctx, key, 0, 0).Result()
+	if err == nil && len(oldest) > 0 && int64(oldest[0].Score) <= afterSeq+1 {
+		rels, _ = utils.RedisCluster.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+			Min:   "(" + strconv.FormatInt(afterSeq, 10),
+			Max:   "+inf",
+			Count: limit,
+		}).Result()
+	} else {
+		// 缓存里已经没有这么早的消息 从MySQL读  已删除的消息与缓存一样返回墓碑
+		older := make([]Message, 0)
+		utils.DB.Unscoped().Where("type = 2 and thread_id = 0 and target_id = ? and seq > ?", channelId, afterSeq).
+			Order("seq").Limit(int(limit)).Find(&older)
+		rels = marshalMsgs(older)
+	}
+	if len(rels) == 0 {
+		return []ChannelPost{}, 0, "ok"
+	}
+	// 读到的位置按过滤前的最后一条计算 自己隐藏的消息也算已读
+	last := Message{}
+	json.Unmarshal([]byte(rels[len(rels)-1]), &last)
+	msgs := make([]Message, 0, len(rels))
+	for _, v := range filterHiddenMsg(userId, rels) {
+		msg := Message{}
+		if json.Unmarshal([]byte(v), &msg) == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	ids := make([]uint, 0, len(msgs))
+	for _, v := range msgs {
+		if !v.DeletedAt.Valid {
+			ids = append(ids, v.ID)
+		}
+	}
+	RecordViews(userId, ids)
+	threads := make([]Thread, 0)
+	utils.DB.Where("root_msg_id in ?", ids).Find(&threads)
+	threadMap := make(map[uint]uint)
+	for _, v := range threads {
+		threadMap[v.RootMsgId] = v.ID
+	}
+	posts := make([]ChannelPost, 0, len(msgs))
+	for _, v := range msgs {
+		post := ChannelPost{Message: v, ThreadId: threadMap[v.ID]}
+		post.Views, _ = utils.RedisCluster.PFCount(ctx, channelViewKey(v.ID)).Result()
+		if post.ThreadId != 0 {
+			post.Comments, _ = utils.RedisCluster.Get(ctx, "seq_"+threadKey(post.ThreadId)).Int64()
+		}
+		posts = append(posts, post)
+	}
+	readKey := channelReadKey(userId)
+	readSeq, _ := utils.RedisCluster.HGet(ctx, readKey, strconv.Itoa(int(channelId))).Int64()
+	if last.Seq > readSeq {
+		utils.RedisCluster.HSet(ctx, readKey, strconv.Itoa(int(channelId)), last.Seq)
+	}
+	return posts, 0, "ok"
+}
+
+// RecordViews 记录阅读  同一个人多次阅读只算一次
+func RecordViews(userId uint, msgIds []uint) {
+	ctx := context.Background()
+	for _, v := range msgIds {
+		if err := utils.RedisCluster.PFAdd(ctx, channelViewKey(v), userId).Err(); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// ViewChannelPosts 在线收到推送的订阅者上报阅读
+func ViewChannelPosts(userId uint, channelId uint, msgIds []uint) (int, string) {
+	if !IsGroupMember(userId, channelId) {
+		return -1, "没有订阅此频道"
+	}
+	valid := make([]uint, 0, len(msgIds))
+	utils.DB.Model(&Message{}).Where("id in ? and type = 2 and target_id = ?", msgIds, channelId).Pluck("id", &valid)
+	RecordViews(userId, valid)
+	return 0, "ok"
+}
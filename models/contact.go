@@ -2,9 +2,9 @@ package models
 
 import (
 	"GinChat/utils"
-	"errors"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Contact 人员关系
@@ -12,7 +12,7 @@ type Contact struct {
 	gorm.Model
 	OwnerId  uint //谁的关系信息
 	TargetId uint //对应的谁 /群 ID
-	Type     int  //对应的类型  1好友  2群  3xx
+	Type     int  //对应的类型  1好友  2群(旧数据 已迁移到group_member)  3xx
 	Desc     string
 }
 
@@ -20,76 +20,104 @@ func (table *Contact) TableName() string {
 	return "contact"
 }
 
-func SearchFriend(userId uint) []UserBasic {
+// FriendInfo 好友列表项 附带备注名 标签和在线状态
+type FriendInfo struct {
+	UserBasic
+	Remark string   //备注名
+	Tags   []string //标签
+	Online bool     //对方不允许查看在线状态时始终为false
+}
+
+// SearchFriend 好友列表  tag 不为空时只返回带该标签的好友
+func SearchFriend(userId uint, tag string) []FriendInfo {
 	contacts := make([]Contact, 0)
 	objIds := make([]uint64, 0)
-	utils.DB.Where("owner_id = ? and type=1", userId).Find(&contacts)
+	db := utils.DB.Where("owner_id = ? and type=1", userId)
+	if tag != "" {
+		db = db.Where("target_id in (?)", utils.DB.Model(&ContactTag{}).
+			Where("owner_id = ? and name = ?", userId, tag).Select("target_id"))
+	}
+	db.Find(&contacts)
 	for _, v := range contacts {
 		objIds = append(objIds, uint64(v.TargetId))
 	}
 	users := make([]UserBasic, 0)
 	utils.DB.Where("id in ?", objIds).Find(&users)
-	return users
+	remarks := make(map[uint]string)
+	for _, v := range contacts {
+		remarks[v.TargetId] = v.Desc
+	}
+	tags := friendTags(userId)
+	ids := make([]uint, 0, len(users))
+	for _, v := range users {
+		ids = append(ids, v.ID)
+	}
+	visible := CanSeeOnlineBatch(userId, ids)
+	online := onlineStatus(ids)
+	res := make([]FriendInfo, 0, len(users))
+	for _, v := range users {
+		res = append(res, FriendInfo{
+			UserBasic: v,
+			Remark:    remarks[v.ID],
+			Tags:      tags[v.ID],
+			Online:    visible[v.ID] && online[v.ID],
+		})
+	}
+	return res
 }
 
-// AddFriend 添加好友
-// @Param userId 自己的ID
-// @Param targetName	好友的ID
-func AddFriend(userId uint, targetName string) (int, string) {
-
-	if targetName != "" {
-		targetUser, err := FindUserByName(targetName)
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			if targetUser.ID == userId {
-				return -1, "不能加自己"
-			}
-			contact0 := Contact{}
-			utils.DB.Where("owner_id =? and target_id =? and type=1", userId, targetUser.ID).Find(&contact0)
-			if contact0.ID != 0 {
-				return -1, "不能重复添加"
-			}
-
-			contacts := []Contact{
-				{OwnerId: userId, TargetId: targetUser.ID, Type: 1},
-				{OwnerId: targetUser.ID, TargetId: userId, Type: 1},
-			}
-
-			// Method 1 -- Transaction
-			////事务一旦开始，不论什么异常最终都会
-			//tx := utils.DB.Begin()
-			//defer func() {
-			//	if r := recover(); r != nil {
-			//		tx.Rollback()
-			//	}
-			//}()
-			//if err := utils.DB.Create(&contacts[0]).Error; err != nil {
-			//	tx.Rollback()
-			//	return -1, "添加好友失败"
-			//}
-			//if err := utils.DB.Create(&contacts[1]).Error; err != nil {
-			//	tx.Rollback()
-			//	return -1, "添加好友失败"
-			//}
-			//tx.Commit()
-
-			// Method 2 -- Insert Multi Data
-			if err := utils.DB.Create(&contacts).Error; err != nil {
-				return -1, "添加好友失败：" + err.Error()
-			}
+// SetRemark 设置好友备注名 存在自己那一侧的 Contact.Desc
+func SetRemark(userId uint, targetId uint, remark string) (int, string) {
+	if len([]rune(remark)) > 30 {
+		return -1, "备注不能超过30字"
+	}
+	res := utils.DB.Model(&Contact{}).Where("owner_id = ? and target_id = ? and type=1", userId, targetId).
+		Update("desc", remark)
+	if res.Error != nil {
+		return -1, "修改备注失败"
+	}
+	if res.RowsAffected == 0 && !isFriend(userId, targetId) {
+		return -1, "对方不是你的好友"
+	}
+	return 0, "修改备注成功"
+}
 
-			return 0, "添加好友成功"
+// DeleteFriend 删除好友 双向删除关系  clearHistory 时对自己隐藏两人的全部聊天记录
+func DeleteFriend(userId uint, targetId uint, clearHistory bool) (int, string) {
+	if !isFriend(userId, targetId) {
+		return -1, "对方不是你的好友"
+	}
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("owner_id = ? and target_id = ? and type=1", userId, targetId).Delete(&Contact{}).Error; err != nil {
+			return err
 		}
-		return -1, "没有找到此用户"
+		if err := tx.Where("owner_id = ? and target_id = ? and type=1", targetId, userId).Delete(&Contact{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("owner_id = ? and target_id = ?", userId, targetId).Delete(&ContactTag{}).Error
+	})
+	if err != nil {
+		return -1, "删除好友失败"
+	}
+	if clearHistory {
+		clearPrivateHistory(userId, targetId)
 	}
-	return -1, "好友ID不能为空"
+	pushEvent(int64(userId), "friend_delete", map[string]interface{}{"userId": userId, "TargetId": targetId})
+	return 0, "删除好友成功"
 }
 
-func SearchUserByGroupId(communityId uint) []uint {
-	contacts := make([]Contact, 0)
-	objIds := make([]uint, 0)
-	utils.DB.Where("target_id = ? and type=2", communityId).Find(&contacts)
-	for _, v := range contacts {
-		objIds = append(objIds, v.OwnerId)
+// clearPrivateHistory 对自己隐藏与对方的全部私聊记录 对方的记录不受影响
+func clearPrivateHistory(userId uint, targetId uint) {
+	ids := make([]uint, 0)
+	utils.DB.Model(&Message{}).
+		Where("type = 1 and ((user_id = ? and target_id = ?) or (user_id = ? and target_id = ?))", userId, targetId, targetId, userId).
+		Where("id not in (?)", utils.DB.Model(&MessageHidden{}).Where("user_id = ?", userId).Select("message_id")).
+		Pluck("id", &ids)
+	hidden := make([]MessageHidden, 0, len(ids))
+	for _, v := range ids {
+		hidden = append(hidden, MessageHidden{UserId: userId, MessageId: v})
+	}
+	if len(hidden) > 0 {
+		utils.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&hidden, 500)
 	}
-	return objIds
 }
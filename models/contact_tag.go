@@ -0,0 +1,86 @@
+package models
+
+import (
+	"GinChat/utils"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ContactTag 好友标签  一个好友可以有多个标签
+type ContactTag struct {
+	gorm.Model
+	OwnerId  uint   //谁的标签
+	TargetId uint   //打了标签的好友
+	Name     string `gorm:"type:varchar(30)"` //标签名
+}
+
+func (table *ContactTag) TableName() string {
+	return "contact_tag"
+}
+
+// TagInfo 标签及其好友数
+type TagInfo struct {
+	Name  string
+	Count int
+}
+
+// friendTags 用户给每个好友打的标签
+func friendTags(userId uint) map[uint][]string {
+	tags := make([]ContactTag, 0)
+	utils.DB.Where("owner_id = ?", userId).Order("id").Find(&tags)
+	res := make(map[uint][]string)
+	for _, v := range tags {
+		res[v.TargetId] = append(res[v.TargetId], v.Name)
+	}
+	return res
+}
+
+// SetContactTags 覆盖设置某个好友的全部标签
+func SetContactTags(userId uint, targetId uint, names []string) (int, string) {
+	if !isFriend(userId, targetId) {
+		return -1, "对方不是你的好友"
+	}
+	tags := make([]ContactTag, 0, len(names))
+	seen := make(map[string]bool)
+	for _, v := range names {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		if len([]rune(v)) > 30 {
+			return -1, "标签不能超过30字"
+		}
+		seen[v] = true
+		tags = append(tags, ContactTag{OwnerId: userId, TargetId: targetId, Name: v})
+	}
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("owner_id = ? and target_id = ?", userId, targetId).Delete(&ContactTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return tx.Create(&tags).Error
+	})
+	if err != nil {
+		return -1, "设置标签失败"
+	}
+	return 0, "设置标签成功"
+}
+
+// LoadContactTags 标签列表
+func LoadContactTags(userId uint) []TagInfo {
+	res := make([]TagInfo, 0)
+	utils.DB.Model(&ContactTag{}).Where("owner_id = ?", userId).
+		Select("name, count(*) as count").Group("name").Order("name").Scan(&res)
+	return res
+}
+
+// DeleteContactTag 删除标签 好友关系不受影响
+func DeleteContactTag(userId uint, name string) (int, string) {
+	if err := utils.DB.Unscoped().Where("owner_id = ? and name = ?", userId, name).Delete(&ContactTag{}).Error; err != nil {
+		return -1, "删除标签失败"
+	}
+	return 0, "删除标签成功"
+}
@@ -0,0 +1,271 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	DisappearAfterSend = 1 //发送后开始计时
+	DisappearAfterRead = 2 //对方读取后开始计时  群聊中从第一个成员读取时开始计时
+
+	expireQueueKey = "expire_queue" //待焚毁的消息 分数为焚毁时间
+)
+
+// ConversationSetting 会话设置  阅后即焚和消息保留策略
+type ConversationSetting struct {
+	gorm.Model
+	ConvKey        string //私聊 msg_小ID_大ID  群聊 group_群ID
+	DisappearTTL   int    //消息存活秒数 0关闭
+	DisappearMode  int    //1发送后计时 2读取后计时
+	RetentionCount int64  //Redis中保留的最新消息条数 0沿用全局配置
+	RetentionDays  int    //Redis中保留的天数 0沿用全局配置
+	UpdatedBy      uint   //最后修改人
+}
+
+func (table *ConversationSetting) TableName() string {
+	return "conversation_setting"
+}
+
+// FindConvSetting 查找会话设置 没有设置时返回零值
+func FindConvSetting(key string) ConversationSetting {
+	setting := ConversationSetting{}
+	utils.DB.Where("conv_key = ?", key).Find(&setting)
+	return setting
+}
+
+// SetDisappear 设置会话的阅后即焚  群聊只有管理员可以设置  修改后在会话中发出系统消息
+// @Param targetType & targetId	1私聊对方ID  2群ID
+// @Param ttl	消息存活秒数 0关闭
+func SetDisappear(userId uint, targetType int, targetId int64, ttl int, mode int) (int, string) {
+	if ttl < 0 || ttl > 7*24*3600 {
+		return -1, "时长不合法"
+	}
+	if mode != DisappearAfterSend && mode != DisappearAfterRead {
+		mode = DisappearAfterSend
+	}
+	key := msgKey(int64(userId), targetId)
+	if targetType == 2 {
+		if !IsGroupAdmin(userId, uint(targetId)) {
+			return -1, "只有群管理员可以设置"
+		}
+		key = groupKey(uint(targetId))
+	} else if targetType != 1 {
+		return -1, "会话类型不合法"
+	}
+	setting := FindConvSetting(key)
+	setting.ConvKey = key
+	setting.DisappearTTL = ttl
+	setting.DisappearMode = mode
+	setting.UpdatedBy = userId
+	if err := utils.DB.Save(&setting).Error; err != nil {
+		return -1, "设置失败"
+	}
+
+	content := "关闭了阅后即焚"
+	if ttl > 0 {
+		when := "发送"
+		if mode == DisappearAfterRead {
+			when = "读取"
+			if targetType == 2 {
+				when = "首次被读取"
+			}
+		}
+		content = "开启了阅后即焚：消息在" + when + "后" + formatTTL(ttl) + "消失"
+	}
+	_, err := SendMsg(Message{
+		UserId:   int64(userId),
+		TargetId: targetId,
+		Type:     targetType,
+		Media:    6,
+		Content:  FindByID(userId).Name + content,
+	})
+	if err != nil {
+		fmt.Println("system msg send fail: ", err)
+	}
+	return 0, "设置成功"
+}
+
+// formatTTL 秒数转成可读的时长
+func formatTTL(ttl int) string {
+	switch {
+	case ttl%86400 == 0:
+		return strconv.Itoa(ttl/86400) + "天"
+	case ttl%3600 == 0:
+		return strconv.Itoa(ttl/3600) + "小时"
+	case ttl%60 == 0:
+		return strconv.Itoa(ttl/60) + "分钟"
+	}
+	return strconv.Itoa(ttl) + "秒"
+}
+
+// applyDisappear 按会话设置给新消息打上焚毁信息  系统消息不焚毁
+func applyDisappear(msg *Message) {
+	msg.DisappearTTL = 0
+	msg.ExpireAt = 0
+	if msg.Media == 6 {
+		return
+	}
+	setting := FindConvSetting(chatKey(*msg))
+	if setting.DisappearTTL <= 0 {
+		return
+	}
+	msg.DisappearTTL = setting.DisappearTTL
+	if setting.DisappearMode == DisappearAfterSend {
+		msg.ExpireAt = msg.CreateTime + uint64(setting.DisappearTTL)
+	}
+}
+
+// scheduleExpire 将已开始计时的消息加入焚毁队列
+func scheduleExpire(msg Message) {
+	if msg.ExpireAt == 0 {
+		return
+	}
+	utils.RedisCluster.ZAdd(context.Background(), expireQueueKey, redis.Z{Score: float64(msg.ExpireAt), Member: msg.ID})
+}
+
+// ReadMsg 标记消息已读  读取后计时的消息从此刻开始倒计时
+// 消息只有一个焚毁时间 群消息在第一个成员读取时开始计时 之后对所有成员同时消失
+func ReadMsg(userId uint, msgId uint) (int, string) {
+	msg := Message{}
+	utils.DB.Where("id = ?", msgId).Find(&msg)
+	if msg.ID == 0 {
+		return -1, "消息不存在"
+	}
+	if msg.UserId == int64(userId) || !IsMsgVisible(userId, msg) {
+		return -1, "无权操作此消息"
+	}
+	if msg.ReadTime != 0 {
+		return 0, "ok"
+	}
+	now := uint64(time.Now().Unix())
+	updates := map[string]interface{}{"read_time": now}
+	if msg.DisappearTTL > 0 && msg.ExpireAt == 0 {
+		msg.ExpireAt = now + uint64(msg.DisappearTTL)
+		updates["expire_at"] = msg.ExpireAt
+	}
+	utils.DB.Model(&msg).Updates(updates)
+	scheduleExpire(msg)
+	return 0, "ok"
+}
+
+// PurgeExpiredMsgs 焚毁到期的消息  多节点通过 ZREM 抢占 每条消息只处理一次
+func PurgeExpiredMsgs() bool {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("purge expired msgs err", r)
+		}
+	}()
+	ctx := context.Background()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	ids, err := utils.RedisCluster.ZRangeByScore(ctx, expireQueueKey, &redis.ZRangeBy{Min: "-inf", Max: now, Count: 100}).Result()
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+	for _, idStr := range ids {
+		removed, err := utils.RedisCluster.ZRem(ctx, expireQueueKey, idStr).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+		msgId, _ := strconv.Atoi(idStr)
+		purgeMsg(uint(msgId))
+	}
+	return true
+}
+
+// purgeMsg 彻底删除一条消息  MySQL 缓存 附件 回应 置顶和收藏都不再保留
+// 引用了它的回复和合并转发中的内容一并抹掉
+func purgeMsg(msgId uint) {
+	msg, err := FindMsgByID(msgId)
+	if err != nil {
+		return
+	}
+	for _, key := range msgCacheKeys(msg) {
+		replaceCachedMsg(key, msg.ID, msg.Seq, nil)
+	}
+	clearReactions(msg.ID)
+	msgIndex.Remove(msg.ID)
+	scrubQuotes(msg.ID)
+	scrubForwardBundles(msg.ID)
+	for _, url := range []string{msg.Url, msg.Pic} {
+		if !uploadShared(url, msg.ID) {
+			removeUpload(url)
+		}
+	}
+	utils.DB.Unscoped().Delete(&msg)
+	utils.DB.Unscoped().Where("message_id = ?", msg.ID).Delete(&PinnedMessage{})
+	utils.DB.Unscoped().Where("message_id = ?", msg.ID).Delete(&StarredMessage{})
+	utils.DB.Unscoped().Where("message_id = ?", msg.ID).Delete(&MessageHidden{})
+	msgEvent(msg, "msg_expire", msgRef(msg))
+}
+
+// updateMsgEverywhere 更新MySQL中的消息 并替换缓存中的旧内容
+func updateMsgEverywhere(msg Message, updates map[string]interface{}) {
+	utils.DB.Unscoped().Model(&msg).Updates(updates)
+	if fresh, err := FindMsgByID(msg.ID); err == nil {
+		for _, key := range msgCacheKeys(fresh) {
+			replaceCachedMsg(key, fresh.ID, fresh.Seq, &fresh)
+		}
+	}
+}
+
+// scrubQuotes 被焚毁消息的引用片段替换掉
+func scrubQuotes(msgId uint) {
+	replies := make([]Message, 0)
+	utils.DB.Unscoped().Where("parent_id = ?", msgId).Find(&replies)
+	for _, v := range replies {
+		updateMsgEverywhere(v, map[string]interface{}{"quote": "[消息已焚毁]"})
+	}
+}
+
+// scrubForwardBundles 合并转发中包含被焚毁消息时 抹掉那一条的内容
+func scrubForwardBundles(msgId uint) {
+	bundles := make([]Message, 0)
+	pattern := `%{"ID":` + strconv.Itoa(int(msgId)) + `,%`
+	utils.DB.Unscoped().Where("media = 5 and content like ?", pattern).Find(&bundles)
+	for _, v := range bundles {
+		items := make([]ForwardItem, 0)
+		if json.Unmarshal([]byte(v.Content), &items) != nil {
+			continue
+		}
+		for i := range items {
+			if items[i].ID == msgId {
+				items[i].Content = "[消息已焚毁]"
+				items[i].Pic = ""
+				items[i].Url = ""
+			}
+		}
+		content, _ := json.Marshal(items)
+		updateMsgEverywhere(v, map[string]interface{}{"content": string(content)})
+	}
+}
+
+// uploadShared 附件是否还被其他消息引用
+func uploadShared(url string, exceptId uint) bool {
+	if url == "" {
+		return false
+	}
+	var count int64
+	utils.DB.Unscoped().Model(&Message{}).Where("(url = ? or pic = ?) and id <> ?", url, url, exceptId).Count(&count)
+	return count > 0
+}
+
+// removeUpload 删除上传到本地的附件  只处理 asset/upload 目录下的文件
+func removeUpload(url string) {
+	name := uploadName(url)
+	if name == "" {
+		return
+	}
+	if err := os.Remove("./asset/upload/" + name); err != nil && !os.IsNotExist(err) {
+		fmt.Println("remove upload fail: ", err)
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"GinChat/utils"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// DiscoverUser 搜索到的用户  不返回手机号和邮箱
+type DiscoverUser struct {
+	ID            uint
+	Name          string
+	Avatar        string
+	IsFriend      bool
+	MutualFriends int64 //共同好友数
+}
+
+// DiscoverGroup 搜索到的公开群
+type DiscoverGroup struct {
+	GroupBasic
+	Members       int64 //成员数
+	MutualFriends int64 //群里的好友数
+	Joined        bool
+}
+
+var phonePattern = regexp.MustCompile(`^1\d{10}$`)
+
+// escapeLike 转义 LIKE 中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// DiscoverUsers 搜索用户  手机号和邮箱精确匹配 其他按名字前缀匹配
+// 按对方的隐私设置过滤 拉黑了自己的用户不会出现
+// 排序：名字完全一致 > 共同好友多 > 名字短
+func DiscoverUsers(viewerId uint, keyword string, page int, size int) ([]DiscoverUser, int64) {
+	res := make([]DiscoverUser, 0)
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return res, 0
+	}
+	column := "find_by_name"
+	db := utils.DB.Model(&UserBasic{}).Where("user_basic.id <> ?", viewerId)
+	switch {
+	case strings.Contains(keyword, "@"):
+		column = "find_by_email"
+		db = db.Where("user_basic.email = ?", keyword)
+	case phonePattern.MatchString(keyword):
+		column = "find_by_phone"
+		db = db.Where("user_basic.phone = ?", keyword)
+	default:
+		db = db.Where("user_basic.name like ?", escapeLike(keyword)+"%")
+	}
+	// 与 CanFindBy 相同的规则 放进SQL里保证分页准确
+	db = db.Where("not exists (select 1 from user_block b where b.user_id = user_basic.id and b.blocked_id = ? and b.deleted_at is null)", viewerId).
+		Where("not exists (select 1 from privacy_setting p where p.user_id = user_basic.id and p.deleted_at is null and (p."+column+" = ? or (p."+column+" = ? and not exists ("+
+			"select 1 from contact c where c.owner_id = user_basic.id and c.target_id = ? and c.type = 1 and c.deleted_at is null))))",
+			PrivacyNobody, PrivacyFriends, viewerId)
+
+	var total int64
+	db.Count(&total)
+	db.Select("user_basic.id, user_basic.name, user_basic.avatar, "+
+		"exists (select 1 from contact f where f.owner_id = ? and f.target_id = user_basic.id and f.type = 1 and f.deleted_at is null) as is_friend, "+
+		"(select count(*) from contact a join contact b on a.target_id = b.target_id "+
+		"where a.owner_id = ? and a.type = 1 and a.deleted_at is null and b.owner_id = user_basic.id and b.type = 1 and b.deleted_at is null) as mutual_friends",
+		viewerId, viewerId).
+		Order(gorm.Expr("user_basic.name = ? desc", keyword)).
+		Order("mutual_friends desc, char_length(user_basic.name), user_basic.id").
+		Offset((page - 1) * size).Limit(size).Scan(&res)
+	return res, total
+}
+
+// DiscoverGroups 搜索公开群  按群名和简介匹配
+// 排序：群名完全一致 > 群名前缀 > 群里好友多 > 成员多
+func DiscoverGroups(viewerId uint, keyword string, page int, size int) ([]DiscoverGroup, int64) {
+	res := make([]DiscoverGroup, 0)
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return res, 0
+	}
+	like := "%" + escapeLike(keyword) + "%"
+	db := utils.DB.Model(&GroupBasic{}).Where("group_basic.public = ?", true).
+		Where("group_basic.name like ? or group_basic.`desc` like ?", like, like)
+
+	var total int64
+	db.Count(&total)
+	db.Select("group_basic.*, "+
+		"(select count(*) from group_member m where m.group_id = group_basic.id and m.deleted_at is null) as members, "+
+		"(select count(*) from group_member m join contact c on c.target_id = m.user_id "+
+		"where m.group_id = group_basic.id and m.deleted_at is null and c.owner_id = ? and c.type = 1 and c.deleted_at is null) as mutual_friends, "+
+		"exists (select 1 from group_member j where j.group_id = group_basic.id and j.user_id = ? and j.deleted_at is null) as joined",
+		viewerId, viewerId).
+		Order(gorm.Expr("group_basic.name = ? desc, group_basic.name like ? desc", keyword, escapeLike(keyword)+"%")).
+		Order("mutual_friends desc, members desc, group_basic.id").
+		Offset((page - 1) * size).Limit(size).Scan(&res)
+	return res, total
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event 服务端主动推送给客户端的事件通知
+type Event struct {
+	Type     int         //固定为 4 事件通知
+	TargetId int64       //接收者
+	Event    string      //事件名称  msg_delete 消息删除
+	Data     interface{} //事件内容
+	GroupId  int64       `json:",omitempty"` //不为0时推送给该群所有成员
+}
+
+// pushEvent 推送事件给某个用户 本节点不在线时经广播交给其他节点
+func pushEvent(userId int64, event string, data interface{}) {
+	ev := Event{Type: 4, TargetId: userId, Event: event, Data: data}
+	bytes, err := json.Marshal(ev)
+	if err != nil {
+		fmt.Println("event marshal fail: ", err)
+		return
+	}
+	dispatch(bytes)
+	broadMsg(bytes)
+}
+
+// groupEvent 推送事件给群里所有成员  整个群只广播一次 各节点投递给本地的成员
+func groupEvent(groupId uint, event string, data interface{}) {
+	ev := Event{Type: 4, Event: event, Data: data, GroupId: int64(groupId)}
+	bytes, err := json.Marshal(ev)
+	if err != nil {
+		fmt.Println("event marshal fail: ", err)
+		return
+	}
+	dispatch(bytes)
+	broadMsg(bytes)
+}
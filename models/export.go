@@ -0,0 +1,432 @@
+package models
+
+import (
+	"GinChat/utils"
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+// 导出/导入任务状态
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// ArchiveJob 聊天记录导出/导入的异步任务  存在Redis中供进度查询
+type ArchiveJob struct {
+	ID       string
+	UserId   uint
+	Kind     string //export 导出  import 导入
+	Status   string
+	Progress int    //0-100
+	Total    int    //需要处理的消息条数
+	Done     int    //已处理条数
+	Skipped  int    //导入时跳过的条数
+	File     string //导出文件 或 上传的导入文件
+	Error    string
+}
+
+func archiveJobKey(jobId string) string {
+	return "archive_job_" + jobId
+}
+
+// exportUserKey 用户发起过的导出任务ID  注销时据此删除导出文件
+func exportUserKey(userId uint) string {
+	return "export_user_" + strconv.Itoa(int(userId))
+}
+
+// ExportDir 导出文件目录  不放在 asset 下避免被静态路由直接访问
+func ExportDir() string {
+	dir := viper.GetString("export.dir")
+	if dir == "" {
+		dir = "./export/"
+	}
+	return dir
+}
+
+// FindArchiveJob 查询任务进度 只能查自己的任务
+func FindArchiveJob(userId uint, jobId string) (ArchiveJob, int, string) {
+	job := ArchiveJob{}
+	data, err := utils.RedisCluster.Get(context.Background(), archiveJobKey(jobId)).Result()
+	if err != nil || json.Unmarshal([]byte(data), &job) != nil || job.UserId != userId {
+		return job, -1, "任务不存在"
+	}
+	return job, 0, "ok"
+}
+
+// saveArchiveJob 任务信息保留7天
+func saveArchiveJob(job *ArchiveJob) {
+	if job.Total > 0 {
+		job.Progress = job.Done * 100 / job.Total
+	}
+	data, _ := json.Marshal(job)
+	utils.RedisCluster.Set(context.Background(), archiveJobKey(job.ID), data, 7*24*time.Hour)
+}
+
+// failArchiveJob 任务失败
+func failArchiveJob(job *ArchiveJob, err error) {
+	fmt.Println("archive job fail: ", job.ID, err)
+	job.Status = JobFailed
+	job.Error = err.Error()
+	saveArchiveJob(job)
+}
+
+// StartExport 发起导出任务
+// @Param targetType & targetId	只导出一个会话 1私聊对方ID 2群ID  targetType为0时导出整个账号
+func StartExport(userId uint, targetType int, targetId int64) (ArchiveJob, int, string) {
+	if targetType == 2 && !IsGroupMember(userId, uint(targetId)) {
+		return ArchiveJob{}, -1, "不是群成员"
+	}
+	job := ArchiveJob{ID: uuid.NewString(), UserId: userId, Kind: "export", Status: JobPending}
+	saveArchiveJob(&job)
+	utils.RedisCluster.SAdd(context.Background(), exportUserKey(userId), job.ID)
+	go runExport(&job, targetType, targetId)
+	return job, 0, "导出任务已创建"
+}
+
+// runExport 生成 zip 导出包  messages.jsonl  transcript.html  transcript.md  attachments/
+func runExport(job *ArchiveJob, targetType int, targetId int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			failArchiveJob(job, fmt.Errorf("%v", r))
+		}
+	}()
+	job.Status = JobRunning
+	saveArchiveJob(job)
+
+	db := accessibleMsgs(utils.DB.Model(&Message{}), job.UserId)
+	if targetType == 1 {
+		db = db.Where("type = 1 and ((user_id = ? and target_id = ?) or (user_id = ? and target_id = ?))",
+			job.UserId, targetId, targetId, job.UserId)
+	} else if targetType == 2 {
+		db = db.Where("type = 2 and target_id = ?", targetId)
+	}
+	msgs := make([]Message, 0)
+	if err := db.Order("id").Find(&msgs).Error; err != nil {
+		failArchiveJob(job, err)
+		return
+	}
+	job.Total = len(msgs)
+	saveArchiveJob(job)
+
+	if err := os.MkdirAll(ExportDir(), 0755); err != nil {
+		failArchiveJob(job, err)
+		return
+	}
+	file := filepath.Join(ExportDir(), job.ID+".zip")
+	out, err := os.Create(file)
+	if err != nil {
+		failArchiveJob(job, err)
+		return
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+
+	names := userNames(msgs)
+	jsonl, _ := zw.Create("messages.jsonl")
+	var htmlBuf, mdBuf strings.Builder
+	htmlBuf.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>聊天记录</title></head><body>\n")
+	mdBuf.WriteString("# 聊天记录\n\n")
+	attachments := make(map[string]bool)
+	for i, v := range msgs {
+		line, _ := json.Marshal(v)
+		jsonl.Write(append(line, '\n'))
+
+		when := time.Unix(int64(v.CreateTime), 0).Format("2006-01-02 15:04:05")
+		text := transcriptText(v)
+		htmlBuf.WriteString(fmt.Sprintf("<p><small>%s</small> <b>%s</b>: %s</p>\n",
+			when, html.EscapeString(names[v.UserId]), html.EscapeString(text)))
+		mdBuf.WriteString(fmt.Sprintf("- `%s` **%s**: %s\n", when, names[v.UserId], text))
+
+		for _, url := range []string{v.Url, v.Pic} {
+			if name := uploadName(url); name != "" {
+				attachments[name] = true
+			}
+		}
+		job.Done = i + 1
+		if job.Done%200 == 0 {
+			saveArchiveJob(job)
+		}
+	}
+	htmlBuf.WriteString("</body></html>\n")
+	w, _ := zw.Create("transcript.html")
+	w.Write([]byte(htmlBuf.String()))
+	w, _ = zw.Create("transcript.md")
+	w.Write([]byte(mdBuf.String()))
+	for name := range attachments {
+		if err := zipFile(zw, "attachments/"+name, "./asset/upload/"+name); err != nil {
+			fmt.Println("export attachment skip: ", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		failArchiveJob(job, err)
+		return
+	}
+	job.File = file
+	job.Status = JobDone
+	job.Progress = 100
+	saveArchiveJob(job)
+}
+
+// userNames 消息发送者的用户名
+func userNames(msgs []Message) map[int64]string {
+	ids := make([]int64, 0)
+	for _, v := range msgs {
+		ids = append(ids, v.UserId)
+	}
+	users := make([]UserBasic, 0)
+	utils.DB.Unscoped().Where("id in ?", ids).Find(&users)
+	names := make(map[int64]string)
+	for _, v := range users {
+		names[int64(v.ID)] = v.Name
+	}
+	return names
+}
+
+// transcriptText 消息在可读记录中的文字
+func transcriptText(msg Message) string {
+	switch msg.Media {
+	case 3, 4:
+		if name := uploadName(msg.Url); name != "" {
+			return msgSnippet(msg) + " attachments/" + name
+		}
+		return msgSnippet(msg) + " " + msg.Url
+	case 5:
+		return "[聊天记录]"
+	}
+	return msg.Content
+}
+
+// uploadName 本地上传附件的文件名  非本地附件返回空
+func uploadName(url string) string {
+	path := strings.TrimPrefix(strings.TrimPrefix(url, "./"), "/")
+	if !strings.HasPrefix(path, "asset/upload/") || strings.Contains(path, "..") {
+		return ""
+	}
+	return strings.TrimPrefix(path, "asset/upload/")
+}
+
+func zipFile(zw *zip.Writer, name string, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// StartImport 发起导入任务  file 为已保存到本地的导出包
+func StartImport(userId uint, file string) (ArchiveJob, int, string) {
+	job := ArchiveJob{ID: uuid.NewString(), UserId: userId, Kind: "import", Status: JobPending, File: file}
+	saveArchiveJob(&job)
+	go runImport(&job)
+	return job, 0, "导入任务已创建"
+}
+
+// runImport 还原导出包  只导入本人发送的消息 以本人身份重新落库
+// 与正常发送一样经过拉黑 禁言 频道只读等检查 分配新的消息ID和会话序号 不再投递给在线用户
+// 附件解压到本次任务自己的目录 消息中的地址随之改写
+func runImport(job *ArchiveJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			failArchiveJob(job, fmt.Errorf("%v", r))
+		}
+	}()
+	job.Status = JobRunning
+	saveArchiveJob(job)
+
+	zr, err := zip.OpenReader(job.File)
+	if err != nil {
+		failArchiveJob(job, err)
+		return
+	}
+	defer zr.Close()
+
+	msgs := make([]Message, 0)
+	attachments := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "attachments/") {
+			attachments[filepath.Base(f.Name)] = f
+			continue
+		}
+		if f.Name != "messages.jsonl" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			failArchiveJob(job, err)
+			return
+		}
+		scanner := bufio.NewScanner(rc)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		for scanner.Scan() {
+			msg := Message{}
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil {
+				msgs = append(msgs, msg)
+			}
+		}
+		rc.Close()
+	}
+	// 按原消息ID即发送顺序还原 回复总是在被回复的消息之后
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+	job.Total = len(msgs)
+	saveArchiveJob(job)
+
+	ctx := context.Background()
+	dir := "import/" + job.ID + "/"
+	importedKey := "imported_" + strconv.Itoa(int(job.UserId))
+	newIds := make(map[uint]uint)
+	for i, v := range msgs {
+		job.Done = i + 1
+		if job.Done%200 == 0 {
+			saveArchiveJob(job)
+		}
+		if !importable(job.UserId, v) {
+			job.Skipped++
+			continue
+		}
+		// 同一条消息只导入一次 原实例上仍存在的消息不重复导入
+		origin := strconv.Itoa(int(v.ID)) + "_" + strconv.FormatUint(v.CreateTime, 10)
+		if added, _ := utils.RedisCluster.SAdd(ctx, importedKey, origin).Result(); added == 0 {
+			job.Skipped++
+			continue
+		}
+		var exists int64
+		utils.DB.Unscoped().Model(&Message{}).Where("id = ? and user_id = ? and create_time = ?", v.ID, v.UserId, v.CreateTime).Count(&exists)
+		if exists > 0 {
+			job.Skipped++
+			continue
+		}
+		msg := Message{
+			UserId:   int64(job.UserId),
+			TargetId: v.TargetId,
+			Type:     v.Type,
+			Media:    v.Media,
+			Content:  v.Content,
+			Pic:      importUpload(v.Pic, dir, attachments),
+			Url:      importUpload(v.Url, dir, attachments),
+			Desc:     v.Desc,
+			Amount:   v.Amount,
+			ParentId: newIds[v.ParentId],
+		}
+		if v.ThreadId != 0 && FindThreadByID(v.ThreadId).GroupId == uint(v.TargetId) {
+			msg.ThreadId = v.ThreadId
+		}
+		if err := storeMsg(&msg); err != nil {
+			fmt.Println("import msg skip: ", v.ID, err)
+			utils.RedisCluster.SRem(ctx, importedKey, origin)
+			job.Skipped++
+			continue
+		}
+		newIds[v.ID] = msg.ID
+		// 群消息和话题消息已由 storeMsg 写入时间线 私聊写入双方的会话缓存
+		if msg.Type == 1 && msg.ThreadId == 0 {
+			utils.RedisCluster.ZAdd(ctx, convKey(msg), redis.Z{Score: float64(msg.Seq), Member: msg})
+		}
+	}
+	job.Status = JobDone
+	job.Progress = 100
+	saveArchiveJob(job)
+}
+
+// importable 只能导入自己发送的普通消息  合并转发和系统消息只能由服务端产生
+func importable(userId uint, msg Message) bool {
+	if msg.ID == 0 || msg.UserId != int64(userId) || (msg.Type != 1 && msg.Type != 2) {
+		return false
+	}
+	return msg.Media != 5 && msg.Media != 6
+}
+
+// importUpload 把消息引用的附件解压到任务目录 返回新的地址  包里没有的附件去掉地址
+func importUpload(url string, dir string, attachments map[string]*zip.File) string {
+	name := uploadName(url)
+	if name == "" {
+		return url
+	}
+	f, ok := attachments[filepath.Base(name)]
+	if !ok {
+		return ""
+	}
+	path := "./asset/upload/" + dir + filepath.Base(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Println("import attachment skip: ", name, err)
+		return ""
+	}
+	if err := unzipFile(f, path); err != nil {
+		fmt.Println("import attachment skip: ", name, err)
+		return ""
+	}
+	return "./asset/upload/" + dir + filepath.Base(name)
+}
+
+// unzipFile 解压单个附件 已存在的同名文件不覆盖
+func unzipFile(f *zip.File, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, rc)
+	return err
+}
+
+// removeUserExports 删除用户的全部导出文件和任务记录
+func removeUserExports(userId uint) {
+	ctx := context.Background()
+	ids, err := utils.RedisCluster.SMembers(ctx, exportUserKey(userId)).Result()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, id := range ids {
+		if err := os.Remove(filepath.Join(ExportDir(), id+".zip")); err != nil && !os.IsNotExist(err) {
+			fmt.Println("remove export fail: ", id, err)
+		}
+		utils.RedisCluster.Del(ctx, archiveJobKey(id))
+	}
+	utils.RedisCluster.Del(ctx, exportUserKey(userId))
+}
+
+// ArchiveFile 导出完成后的文件路径
+func ArchiveFile(userId uint, jobId string) (string, int, string) {
+	job, code, msg := FindArchiveJob(userId, jobId)
+	if code != 0 {
+		return "", code, msg
+	}
+	if job.Kind != "export" || job.Status != JobDone {
+		return "", -1, "导出尚未完成"
+	}
+	return job.File, 0, "ok"
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"GinChat/utils"
+	"encoding/json"
+	"fmt"
+)
+
+// ForwardItem 合并转发中的一条原消息
+type ForwardItem struct {
+	ID         uint
+	UserId     int64 `json:"userId"` //原发送者
+	Media      int
+	Content    string
+	Pic        string
+	Url        string `json:"url"`
+	Amount     int    `json:"amount"`
+	CreateTime uint64
+}
+
+// ForwardMsg 转发消息到私聊或群聊
+// @Param userId 	转发人
+// @Param msgIds 	原消息ID
+// @Param targetType & targetId	目标会话  1私聊  2群聊
+// @Param merge		是否合并为一条聊天记录
+func ForwardMsg(userId uint, msgIds []uint, targetType int, targetId int64, merge bool) ([]Message, int, string) {
+	if len(msgIds) == 0 {
+		return nil, -1, "请选择要转发的消息"
+	}
+	if targetType != 1 && targetType != 2 {
+		return nil, -1, "转发目标不合法"
+	}
+	if targetType == 2 && !IsGroupMember(userId, uint(targetId)) {
+		return nil, -1, "不是目标群的成员"
+	}
+	// 包含已撤回的消息 以便明确拒绝而不是当作不存在
+	originals := make([]Message, 0)
+	utils.DB.Unscoped().Where("id in ?", msgIds).Order("id").Find(&originals)
+	if len(originals) != len(msgIds) {
+		return nil, -1, "部分消息不存在"
+	}
+	hidden := int64(0)
+	utils.DB.Model(&MessageHidden{}).Where("user_id = ? and message_id in ?", userId, msgIds).Count(&hidden)
+	if hidden > 0 {
+		return nil, -1, "已删除的消息不能转发"
+	}
+	for _, v := range originals {
+		if !IsMsgVisible(userId, v) {
+			return nil, -1, "无权转发此消息"
+		}
+		if v.DeletedAt.Valid {
+			return nil, -1, "已撤回的消息不能转发"
+		}
+		// 阅后即焚的消息转发出去就无法按时焚毁
+		if v.DisappearTTL > 0 {
+			return nil, -1, "阅后即焚消息不能转发"
+		}
+	}
+
+	sent := make([]Message, 0)
+	if merge {
+		items := make([]ForwardItem, 0, len(originals))
+		for _, v := range originals {
+			items = append(items, ForwardItem{
+				ID:         v.ID,
+				UserId:     forwardSource(v),
+				Media:      v.Media,
+				Content:    v.Content,
+				Pic:        v.Pic,
+				Url:        v.Url,
+				Amount:     v.Amount,
+				CreateTime: v.CreateTime,
+			})
+		}
+		content, _ := json.Marshal(items)
+		bundle := Message{
+			UserId:   int64(userId),
+			TargetId: targetId,
+			Type:     targetType,
+			Media:    5,
+			Content:  string(content),
+			Desc:     "聊天记录",
+			Amount:   len(items),
+		}
+		msg, err := SendMsg(bundle)
+		if err != nil {
+			fmt.Println("forward fail: ", err)
+			return nil, -1, "转发失败"
+		}
+		return append(sent, msg), 0, "转发成功"
+	}
+
+	for _, v := range originals {
+		copied := Message{
+			UserId:       int64(userId),
+			TargetId:     targetId,
+			Type:         targetType,
+			Media:        v.Media,
+			Content:      v.Content,
+			Pic:          v.Pic,
+			Url:          v.Url,
+			Desc:         v.Desc,
+			Amount:       v.Amount,
+			ForwardFrom:  forwardSource(v),
+			ForwardMsgId: v.ID,
+		}
+		msg, err := SendMsg(copied)
+		if err != nil {
+			fmt.Println("forward fail: ", err)
+			return sent, -1, "转发失败"
+		}
+		sent = append(sent, msg)
+	}
+	return sent, 0, "转发成功"
+}
+
+// forwardSource 多次转发时保留最初的发送者
+func forwardSource(msg Message) int64 {
+	if msg.ForwardFrom != 0 {
+		return msg.ForwardFrom
+	}
+	return msg.UserId
+}
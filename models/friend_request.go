@@ -0,0 +1,204 @@
+package models
+
+import (
+	"GinChat/utils"
+	"errors"
+	"time"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// 好友申请状态
+const (
+	RequestPending  = 0 //待处理
+	RequestAccepted = 1 //已同意
+	RequestRejected = 2 //已拒绝
+	RequestExpired  = 3 //已过期
+)
+
+// FriendRequest 好友申请  同意后才建立双向好友关系
+type FriendRequest struct {
+	gorm.Model
+	FromId   uint   //申请人
+	ToId     uint   //被申请人
+	Greeting string //验证消息
+	Status   int    //0待处理 1已同意 2已拒绝 3已过期
+	ExpireAt time.Time
+}
+
+func (table *FriendRequest) TableName() string {
+	return "friend_request"
+}
+
+// FriendRequestInfo 申请列表项 附带对方的名字和头像
+type FriendRequestInfo struct {
+	FriendRequest
+	Name   string //对方用户名
+	Avatar string //对方头像
+}
+
+// isFriend 是否已是好友
+func isFriend(userId uint, targetId uint) bool {
+	contact := Contact{}
+	utils.DB.Where("owner_id = ? and target_id = ? and type=1", userId, targetId).Find(&contact)
+	return contact.ID != 0
+}
+
+// AddFriend 添加好友 只发出好友申请  对方已向自己发起过申请时直接同意
+// @Param userId 自己的ID
+// @Param targetName	好友的名字
+// @Param greeting	验证消息
+func AddFriend(userId uint, targetName string, greeting string) (int, string) {
+	if targetName == "" {
+		return -1, "好友ID不能为空"
+	}
+	targetUser, err := FindUserByName(targetName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return -1, "没有找到此用户"
+	}
+	if targetUser.ID == userId {
+		return -1, "不能加自己"
+	}
+	if !CanFindBy("name", userId, targetUser.ID) {
+		return -1, "没有找到此用户"
+	}
+	if isFriend(userId, targetUser.ID) {
+		return -1, "不能重复添加"
+	}
+	expirePendingRequests()
+
+	reverse := FriendRequest{}
+	utils.DB.Where("from_id = ? and to_id = ? and status = ?", targetUser.ID, userId, RequestPending).Find(&reverse)
+	if reverse.ID != 0 {
+		return AcceptFriendRequest(userId, reverse.ID)
+	}
+	request := FriendRequest{}
+	utils.DB.Where("from_id = ? and to_id = ? and status = ?", userId, targetUser.ID, RequestPending).Find(&request)
+	if request.ID != 0 {
+		return -1, "已发送过申请 请等待对方处理"
+	}
+	if !canAddFriend(userId, targetUser.ID) {
+		return -1, "对方不允许添加好友"
+	}
+	if len([]rune(greeting)) > 100 {
+		return -1, "验证消息不能超过100字"
+	}
+	request = FriendRequest{
+		FromId:   userId,
+		ToId:     targetUser.ID,
+		Greeting: greeting,
+		Status:   RequestPending,
+		ExpireAt: time.Now().Add(time.Duration(viper.GetInt("friend.RequestExpireDays")) * 24 * time.Hour),
+	}
+	if err := utils.DB.Create(&request).Error; err != nil {
+		return -1, "发送申请失败"
+	}
+	from := FindByID(userId)
+	pushEvent(int64(targetUser.ID), "friend_request", FriendRequestInfo{FriendRequest: request, Name: from.Name, Avatar: from.Avatar})
+	return 0, "好友申请已发送"
+}
+
+// AcceptFriendRequest 同意好友申请 建立双向好友关系
+func AcceptFriendRequest(userId uint, requestId uint) (int, string) {
+	request, code, msg := pendingRequestTo(userId, requestId)
+	if code != 0 {
+		return code, msg
+	}
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&FriendRequest{}).Where("id = ? and status = ?", request.ID, RequestPending).
+			Update("status", RequestAccepted)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("request already handled")
+		}
+		if isFriend(request.FromId, request.ToId) {
+			return nil
+		}
+		contacts := []Contact{
+			{OwnerId: request.FromId, TargetId: request.ToId, Type: 1},
+			{OwnerId: request.ToId, TargetId: request.FromId, Type: 1},
+		}
+		return tx.Create(&contacts).Error
+	})
+	if err != nil {
+		return -1, "添加好友失败"
+	}
+	request.Status = RequestAccepted
+	to := FindByID(userId)
+	pushEvent(int64(request.FromId), "friend_accept", FriendRequestInfo{FriendRequest: request, Name: to.Name, Avatar: to.Avatar})
+	return 0, "添加好友成功"
+}
+
+// RejectFriendRequest 拒绝好友申请
+func RejectFriendRequest(userId uint, requestId uint) (int, string) {
+	request, code, msg := pendingRequestTo(userId, requestId)
+	if code != 0 {
+		return code, msg
+	}
+	res := utils.DB.Model(&FriendRequest{}).Where("id = ? and status = ?", request.ID, RequestPending).
+		Update("status", RequestRejected)
+	if res.RowsAffected == 0 {
+		return -1, "申请已处理"
+	}
+	request.Status = RequestRejected
+	pushEvent(int64(request.FromId), "friend_reject", request)
+	return 0, "已拒绝"
+}
+
+// pendingRequestTo 发给自己的待处理申请
+func pendingRequestTo(userId uint, requestId uint) (FriendRequest, int, string) {
+	request := FriendRequest{}
+	utils.DB.Where("id = ? and to_id = ?", requestId, userId).Find(&request)
+	if request.ID == 0 {
+		return request, -1, "申请不存在"
+	}
+	if request.Status == RequestPending && request.ExpireAt.Before(time.Now()) {
+		utils.DB.Model(&request).Update("status", RequestExpired)
+		return request, -1, "申请已过期"
+	}
+	if request.Status != RequestPending {
+		return request, -1, "申请已处理"
+	}
+	return request, 0, ""
+}
+
+// expirePendingRequests 将已过期的待处理申请标记为过期
+func expirePendingRequests() {
+	utils.DB.Model(&FriendRequest{}).Where("status = ? and expire_at < ?", RequestPending, time.Now()).
+		Update("status", RequestExpired)
+}
+
+// LoadFriendRequests 好友申请列表
+// @Param incoming	true 收到的申请  false 发出的申请
+func LoadFriendRequests(userId uint, incoming bool) []FriendRequestInfo {
+	expirePendingRequests()
+	requests := make([]FriendRequest, 0)
+	column := "to_id"
+	if !incoming {
+		column = "from_id"
+	}
+	utils.DB.Where(column+" = ?", userId).Order("id desc").Limit(100).Find(&requests)
+	ids := make([]uint, 0, len(requests))
+	for _, v := range requests {
+		if incoming {
+			ids = append(ids, v.FromId)
+		} else {
+			ids = append(ids, v.ToId)
+		}
+	}
+	users := make([]UserBasic, 0)
+	utils.DB.Where("id in ?", ids).Select("id", "name", "avatar").Find(&users)
+	userMap := make(map[uint]UserBasic)
+	for _, v := range users {
+		userMap[v.ID] = v
+	}
+	res := make([]FriendRequestInfo, 0, len(requests))
+	for i, v := range requests {
+		user := userMap[ids[i]]
+		res = append(res, FriendRequestInfo{FriendRequest: v, Name: user.Name, Avatar: user.Avatar})
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"GinChat/utils"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// 群类型
+const (
+	GroupNormal     = 0 //普通群
+	GroupChannel    = 1 //广播频道
+	GroupDepartment = 2 //部门群
+)
+
+// GroupBasic 群  普通群 广播频道和部门群共用一个模型
+type GroupBasic struct {
+	gorm.Model
+	Name         string
+	OwnerId      uint
+	Icon         string
+	Type         int //0普通群 1广播频道 2部门群
+	Desc         string
+	JoinPolicy   int  //入群方式 0直接加入 1需要审核 2仅邀请
+	MaxMembers   int  //人数上限 0使用默认配置
+	MuteAll      bool //全员禁言
+	MemberInvite bool `gorm:"default:true"` //普通成员可以邀请
+	HideHistory  bool //新成员看不到入群前的消息
+	SlowMode     int  //慢速模式 每个成员每隔多少秒才能发言 0关闭
+	AllowComment bool //频道是否允许订阅者评论
+	Public       bool //公开群 可以被搜索到
+}
+
+func (table *GroupBasic) TableName() string {
+	return "group_basic"
+}
+
+func CreateCommunity(community GroupBasic) (int, string) {
+	//事务一旦开始，不论什么异常最终都会 Rollback
+	tx := utils.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if len(community.Name) == 0 {
+		return -1, "群名称不能为空"
+	}
+	if community.OwnerId == 0 {
+		return -1, "请先登录"
+	}
+	if community.Type < GroupNormal || community.Type > GroupDepartment {
+		return -1, "群类型不正确"
+	}
+	if err := tx.Create(&community).Error; err != nil {
+		fmt.Println(err)
+		tx.Rollback()
+		return -1, "建群失败"
+	}
+	if err := addGroupMember(tx, community.ID, community.OwnerId, RoleOwner); err != nil {
+		tx.Rollback()
+		return -1, "添加群关系失败"
+	}
+
+	tx.Commit()
+	invalidateGroupMembers(community.ID)
+	return 0, "建群成功"
+}
+
+// CommunityInfo 群列表项 附带当前用户的@数 免打扰状态和未确认的公告数
+type CommunityInfo struct {
+	*GroupBasic
+	MentionCount int64 //未读的@我
+	Muted        bool  //是否免打扰
+	UnackedCount int64 //未确认的置顶公告
+}
+
+func LoadCommunity(ownerId uint) ([]*CommunityInfo, string) {
+	objIds := make([]uint64, 0)
+	utils.DB.Model(&GroupMember{}).Where("user_id = ?", ownerId).Pluck("group_id", &objIds)
+
+	data := make([]*GroupBasic, 10)
+	utils.DB.Where("id in ?", objIds).Find(&data)
+	counts := MentionCounts(ownerId)
+	unacked := unackedAnnouncements(ownerId, objIds)
+	res := make([]*CommunityInfo, 0, len(data))
+	for _, v := range data {
+		fmt.Println(v)
+		res = append(res, &CommunityInfo{
+			GroupBasic:   v,
+			MentionCount: counts[v.ID],
+			Muted:        IsGroupMuted(ownerId, v.ID),
+			UnackedCount: unacked[v.ID],
+		})
+	}
+
+	return res, "查询成功"
+}
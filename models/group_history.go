@@ -0,0 +1,151 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func groupKey(groupId uint) string {
+	return "group_" + strconv.Itoa(int(groupId))
+}
+
+// groupHistoryFloor 用户在群时间线上能看到的起点序号  群设置了新成员不可见历史时为入群时的序号
+func groupHistoryFloor(userId uint, groupId uint) (int64, bool) {
+	member := FindGroupMember(groupId, userId)
+	if member.ID == 0 {
+		return 0, false
+	}
+	if member.Role == RoleOwner || !findCommunity(groupId).HideHistory {
+		return 0, true
+	}
+	return member.JoinSeq, true
+}
+
+// canSeeGroupMsg 群成员能否看到这条群消息  与时间线一样按入群时的序号判断
+func canSeeGroupMsg(userId uint, msg Message) bool {
+	floor, ok := groupHistoryFloor(userId, uint(msg.TargetId))
+	return ok && groupTimelineSeq(msg) > floor
+}
+
+// groupTimelineSeq 消息在群时间线上的位置  话题消息以话题根消息为准
+func groupTimelineSeq(msg Message) int64 {
+	if msg.ThreadId == 0 {
+		return msg.Seq
+	}
+	root, err := FindMsgByID(FindThreadByID(msg.ThreadId).RootMsgId)
+	if err != nil {
+		return 0
+	}
+	return root.Seq
+}
+
+// GroupMsg 获取群时间线上的历史消息  用法与 RedisMsg 相同
+// @Param start & end	消息获取idx范围
+// @Param isRev			是否需要反转
+func GroupMsg(userId uint, groupId uint, start int64, end int64, isRev bool) ([]string, int, string) {
+	floor, ok := groupHistoryFloor(userId, groupId)
+	if !ok {
+		return nil, -1, "不是群成员"
+	}
+	ctx := context.Background()
+	key := groupKey(groupId)
+	count := end - start + 1
+	if end < 0 {
+		count = -1
+	}
+	by := &redis.ZRangeBy{
+		Min:    "(" + strconv.FormatInt(floor, 10),
+		Max:    "+inf",
+		Offset: start,
+		Count:  count,
+	}
+	var rels []string
+	var err error
+	if isRev {
+		rels, err = utils.RedisCluster.ZRangeByScore(ctx, key, by).Result()
+	} else {
+		rels, err = utils.RedisCluster.ZRevRangeByScore(ctx, key, by).Result()
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	if !isRev && end >= 0 && int64(len(rels)) < count {
+		rels = append(rels, olderGroupFromMySQL(groupId, floor, start, count-int64(len(rels)))...)
+	}
+	return attachReactions(filterHiddenMsg(userId, rels)), 0, "ok"
+}
+
+// olderGroupFromMySQL 群时间线缓存不够一页时 从MySQL补齐更早的消息
+func olderGroupFromMySQL(groupId uint, floor int64, start int64, limit int64) []string {
+	ctx := context.Background()
+	key := groupKey(groupId)
+	by := &redis.ZRangeBy{Min: "(" + strconv.FormatInt(floor, 10), Max: "+inf"}
+	card, _ := utils.RedisCluster.ZCount(ctx, key, by.Min, by.Max).Result()
+	beforeSeq := int64(-1)
+	if oldest, err := utils.RedisCluster.ZRangeWithScores(ctx, key, 0, 0).Result(); err == nil && len(oldest) > 0 {
+		beforeSeq = int64(oldest[0].Score)
+	}
+	offset := start - card
+	if offset < 0 {
+		offset = 0
+	}
+	msgs := make([]Message, 0)
+	db := utils.DB.Unscoped().Where("type = 2 and thread_id = 0 and target_id = ? and seq > ?", groupId, floor)
+	if beforeSeq >= 0 {
+		db = db.Where("seq < ?", beforeSeq)
+	}
+	db.Order("seq desc").Offset(int(offset)).Limit(int(limit)).Find(&msgs)
+	return marshalMsgs(msgs)
+}
+
+// marshalMsgs 转成与缓存中相同的格式  已删除的消息只保留墓碑
+func marshalMsgs(msgs []Message) []string {
+	rels := make([]string, 0, len(msgs))
+	for _, v := range msgs {
+		if v.DeletedAt.Valid {
+			v = msgTombstone(v)
+		}
+		data, _ := json.Marshal(v)
+		rels = append(rels, string(data))
+	}
+	return rels
+}
+
+// GroupMsgAround 获取群时间线上锚点序号前后的消息  已移出缓存的部分从MySQL读取
+func GroupMsgAround(userId uint, groupId uint, seq int64, count int64) ([]string, int, string) {
+	floor, ok := groupHistoryFloor(userId, groupId)
+	if !ok {
+		return nil, -1, "不是群成员"
+	}
+	min := seq - count
+	if min <= floor {
+		min = floor + 1
+	}
+	max := seq + count
+	ctx := context.Background()
+	key := groupKey(groupId)
+	rels, err := utils.RedisCluster.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: strconv.FormatInt(min, 10),
+		Max: strconv.FormatInt(max, 10),
+	}).Result()
+	if err != nil {
+		fmt.Println(err)
+	}
+	// 缓存中最早的消息之前的部分
+	oldest := max + 1
+	if zs, err := utils.RedisCluster.ZRangeWithScores(ctx, key, 0, 0).Result(); err == nil && len(zs) > 0 {
+		oldest = int64(zs[0].Score)
+	}
+	if min < oldest {
+		msgs := make([]Message, 0)
+		utils.DB.Unscoped().Where("type = 2 and thread_id = 0 and target_id = ? and seq between ? and ?", groupId, min, oldest-1).
+			Order("seq").Find(&msgs)
+		rels = append(marshalMsgs(msgs), rels...)
+	}
+	return attachReactions(filterHiddenMsg(userId, rels)), 0, "ok"
+}
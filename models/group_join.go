@@ -0,0 +1,306 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 入群方式
+const (
+	JoinOpen     = 0 //任何人可直接加入
+	JoinApproval = 1 //需要管理员审核
+	JoinInvite   = 2 //只能通过邀请加入
+)
+
+var errGroupFull = errors.New("group is full")
+
+// GroupJoinRequest 入群申请  需要审核的群在这里排队
+type GroupJoinRequest struct {
+	gorm.Model
+	GroupId   uint
+	UserId    uint   //申请人
+	InviterId uint   //邀请人 0为主动申请
+	Reason    string //申请理由
+	Status    int    //0待审核 1已通过 2已拒绝
+	HandledBy uint   //审核人
+}
+
+func (table *GroupJoinRequest) TableName() string {
+	return "group_join_request"
+}
+
+// GroupInvite 入群邀请码  存在Redis 到期自动失效
+type GroupInvite struct {
+	Code      string
+	GroupId   uint
+	CreatorId uint
+	ExpireAt  int64
+}
+
+func inviteKey(code string) string {
+	return "group_invite_" + code
+}
+
+// groupMaxMembers 群成员上限  群里没有单独设置时用全局配置 频道单独配置
+func groupMaxMembers(community GroupBasic) int {
+	if community.MaxMembers > 0 {
+		return community.MaxMembers
+	}
+	if community.Type == GroupChannel {
+		return viper.GetInt("group.ChannelMaxMembers")
+	}
+	return viper.GetInt("group.MaxMembers")
+}
+
+// joinGroupTx 在事务里加锁检查人数上限后写入成员  并发入群时不会超员
+func joinGroupTx(groupId uint, userId uint) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		community := GroupBasic{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", groupId).First(&community).Error; err != nil {
+			return err
+		}
+		var count int64
+		tx.Model(&GroupMember{}).Where("group_id = ?", groupId).Count(&count)
+		if max := groupMaxMembers(community); max > 0 && int(count) >= max {
+			return errGroupFull
+		}
+		return addGroupMember(tx, groupId, userId, RoleMember)
+	})
+}
+
+// addToGroup 入群并在群里发系统消息
+func addToGroup(groupId uint, userId uint, content string) (int, string) {
+	if err := joinGroupTx(groupId, userId); err != nil {
+		if errors.Is(err, errGroupFull) {
+			return -1, "群成员已满"
+		}
+		return -1, "加群失败"
+	}
+	invalidateGroupMembers(groupId)
+	if content != "" {
+		groupNotice(groupId, userId, content)
+	}
+	return 0, "加群成功"
+}
+
+// JoinGroup 加入群聊  按群的入群方式直接加入或提交申请
+func JoinGroup(userId uint, comIdOrName string, reason string) (int, string) {
+	community := GroupBasic{}
+
+	utils.DB.Where("id=? or name=?", comIdOrName, comIdOrName).Find(&community)
+	if community.Name == "" {
+		return -1, "没有找到群"
+	}
+	if IsGroupMember(userId, community.ID) {
+		return -1, "已加过此群"
+	}
+	switch community.JoinPolicy {
+	case JoinInvite:
+		return -1, "该群只能通过邀请加入"
+	case JoinApproval:
+		return submitJoinRequest(community.ID, userId, 0, reason)
+	}
+	// 频道订阅人数多 不发入群消息
+	content := FindByID(userId).Name + "加入了群聊"
+	if community.Type == GroupChannel {
+		content = ""
+	}
+	return addToGroup(community.ID, userId, content)
+}
+
+// submitJoinRequest 提交入群申请并通知群主和管理员
+func submitJoinRequest(groupId uint, userId uint, inviterId uint, reason string) (int, string) {
+	request := GroupJoinRequest{}
+	utils.DB.Where("group_id = ? and user_id = ? and status = 0", groupId, userId).Find(&request)
+	if request.ID != 0 {
+		return 0, "已提交申请 请等待管理员审核"
+	}
+	request = GroupJoinRequest{GroupId: groupId, UserId: userId, InviterId: inviterId, Reason: reason}
+	if err := utils.DB.Create(&request).Error; err != nil {
+		return -1, "提交申请失败"
+	}
+	for _, v := range groupAdminIds(groupId) {
+		pushEvent(int64(v), "group_join_request", request)
+	}
+	return 0, "已提交申请 请等待管理员审核"
+}
+
+// groupAdminIds 群主和管理员
+func groupAdminIds(groupId uint) []uint {
+	ids := make([]uint, 0)
+	utils.DB.Model(&GroupMember{}).Where("group_id = ? and role >= ?", groupId, RoleAdmin).Pluck("user_id", &ids)
+	return ids
+}
+
+// LoadJoinRequests 待审核的入群申请  仅管理员可见
+func LoadJoinRequests(userId uint, groupId uint) ([]GroupJoinRequest, int, string) {
+	requests := make([]GroupJoinRequest, 0)
+	if !IsGroupAdmin(userId, groupId) {
+		return requests, -1, "没有权限"
+	}
+	utils.DB.Where("group_id = ? and status = 0", groupId).Order("id").Find(&requests)
+	return requests, 0, "ok"
+}
+
+// HandleJoinRequest 审核入群申请
+func HandleJoinRequest(userId uint, requestId uint, approve bool) (int, string) {
+	request := GroupJoinRequest{}
+	utils.DB.Where("id = ?", requestId).Find(&request)
+	if request.ID == 0 {
+		return -1, "申请不存在"
+	}
+	if !IsGroupAdmin(userId, request.GroupId) {
+		return -1, "没有权限"
+	}
+	status := 2
+	if approve {
+		status = 1
+	}
+	// 只有第一个处理的管理员生效
+	res := utils.DB.Model(&GroupJoinRequest{}).Where("id = ? and status = 0", request.ID).
+		Updates(map[string]interface{}{"status": status, "handled_by": userId})
+	if res.RowsAffected == 0 {
+		return -1, "申请已处理"
+	}
+	request.Status = status
+	request.HandledBy = userId
+	if !approve {
+		pushEvent(int64(request.UserId), "group_join_reject", request)
+		return 0, "已拒绝"
+	}
+	if IsGroupMember(request.UserId, request.GroupId) {
+		return 0, "已同意"
+	}
+	code, msg := addToGroup(request.GroupId, request.UserId, FindByID(request.UserId).Name+"加入了群聊")
+	if code != 0 {
+		utils.DB.Model(&request).Update("status", 0)
+		return code, msg
+	}
+	pushEvent(int64(request.UserId), "group_join_approve", request)
+	return 0, "已同意"
+}
+
+// canInvite 是否可以邀请别人入群  管理员总是可以 普通成员看群设置
+func canInvite(userId uint, community GroupBasic) bool {
+	member := FindGroupMember(community.ID, userId)
+	if member.ID == 0 {
+		return false
+	}
+	return member.Role >= RoleAdmin || community.MemberInvite
+}
+
+// InviteMember 邀请好友入群  需要审核的群由非管理员邀请时进入审核队列
+func InviteMember(userId uint, groupId uint, targetId uint) (int, string) {
+	community := GroupBasic{}
+	utils.DB.Where("id = ?", groupId).Find(&community)
+	if community.ID == 0 {
+		return -1, "没有找到群"
+	}
+	if !canInvite(userId, community) {
+		return -1, "没有邀请权限"
+	}
+	if !isFriend(userId, targetId) {
+		return -1, "只能邀请好友"
+	}
+	if IsBlocked(targetId, userId) {
+		return -1, "对方拒绝了你的邀请"
+	}
+	if IsGroupMember(targetId, groupId) {
+		return -1, "对方已在群里"
+	}
+	if community.JoinPolicy == JoinApproval && !IsGroupAdmin(userId, groupId) {
+		return submitJoinRequest(groupId, targetId, userId, "")
+	}
+	code, msg := addToGroup(groupId, targetId, FindByID(userId).Name+"邀请"+FindByID(targetId).Name+"加入了群聊")
+	if code == 0 {
+		pushEvent(int64(targetId), "group_invite", map[string]interface{}{"GroupId": groupId, "userId": userId})
+	}
+	return code, msg
+}
+
+// CreateInvite 生成入群邀请码
+// @Param hours	有效期 0使用默认配置
+func CreateInvite(userId uint, groupId uint, hours int) (GroupInvite, int, string) {
+	community := GroupBasic{}
+	utils.DB.Where("id = ?", groupId).Find(&community)
+	if community.ID == 0 {
+		return GroupInvite{}, -1, "没有找到群"
+	}
+	if !canInvite(userId, community) {
+		return GroupInvite{}, -1, "没有邀请权限"
+	}
+	if hours <= 0 {
+		hours = viper.GetInt("group.InviteExpireHours")
+	}
+	ttl := time.Duration(hours) * time.Hour
+	invite := GroupInvite{
+		Code:      strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")[:8]),
+		GroupId:   groupId,
+		CreatorId: userId,
+		ExpireAt:  time.Now().Add(ttl).Unix(),
+	}
+	data, _ := json.Marshal(invite)
+	ok, err := utils.RedisCluster.SetNX(context.Background(), inviteKey(invite.Code), data, ttl).Result()
+	if err != nil || !ok {
+		return invite, -1, "生成邀请码失败"
+	}
+	return invite, 0, "ok"
+}
+
+// JoinByInvite 通过邀请码入群  管理员生成的邀请码不需要再审核
+// 需要审核的群 普通成员生成的邀请码与直接邀请一样进入审核队列
+func JoinByInvite(userId uint, code string) (int, string) {
+	data, err := utils.RedisCluster.Get(context.Background(), inviteKey(strings.ToUpper(code))).Result()
+	if err != nil {
+		return -1, "邀请码无效或已过期"
+	}
+	invite := GroupInvite{}
+	if json.Unmarshal([]byte(data), &invite) != nil {
+		return -1, "邀请码无效或已过期"
+	}
+	if IsGroupMember(userId, invite.GroupId) {
+		return -1, "已加过此群"
+	}
+	// 邀请人已不在群里或已无邀请权限时邀请码作废
+	community := GroupBasic{}
+	utils.DB.Where("id = ?", invite.GroupId).Find(&community)
+	if community.ID == 0 || !canInvite(invite.CreatorId, community) {
+		return -1, "邀请码无效或已过期"
+	}
+	if IsBlocked(userId, invite.CreatorId) || IsBlocked(invite.CreatorId, userId) {
+		return -1, "邀请码无效或已过期"
+	}
+	if community.JoinPolicy == JoinApproval && !IsGroupAdmin(invite.CreatorId, invite.GroupId) {
+		return submitJoinRequest(invite.GroupId, userId, invite.CreatorId, "通过邀请码申请入群")
+	}
+	content := FindByID(userId).Name + "通过邀请码加入了群聊"
+	if community.Type == GroupChannel {
+		content = ""
+	}
+	return addToGroup(invite.GroupId, userId, content)
+}
+
+// SetJoinPolicy 设置入群方式和人数上限  maxMembers 0使用默认配置
+func SetJoinPolicy(userId uint, groupId uint, policy int, maxMembers int) (int, string) {
+	if policy < JoinOpen || policy > JoinInvite || maxMembers < 0 {
+		return -1, "参数不合法"
+	}
+	if !IsGroupAdmin(userId, groupId) {
+		return -1, "只有群管理员可以设置"
+	}
+	err := utils.DB.Model(&GroupBasic{}).Where("id = ?", groupId).
+		Updates(map[string]interface{}{"join_policy": policy, "max_members": maxMembers}).Error
+	if err != nil {
+		return -1, "设置失败"
+	}
+	return 0, "设置成功"
+}
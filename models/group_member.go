@@ -0,0 +1,253 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// 群成员角色
+const (
+	RoleMember = 0 //普通成员
+	RoleAdmin  = 1 //管理员
+	RoleOwner  = 2 //群主
+)
+
+// GroupMember 群成员关系  退群和被踢时直接删除记录 重新入群重新计算入群时间
+type GroupMember struct {
+	gorm.Model
+	GroupId   uint `gorm:"uniqueIndex:idx_group_member"`
+	UserId    uint `gorm:"uniqueIndex:idx_group_member;index"`
+	Role      int  //0成员 1管理员 2群主
+	JoinedAt  time.Time
+	JoinSeq   int64  //入群时群时间线的序号 新成员不可见历史时从这之后开始
+	MuteUntil int64  //禁言到期时间 unix秒 0未禁言
+	Nickname  string //群昵称
+}
+
+func (table *GroupMember) TableName() string {
+	return "group_member"
+}
+
+// FindGroupMember 查询群成员  不在群里时ID为0
+func FindGroupMember(groupId uint, userId uint) GroupMember {
+	member := GroupMember{}
+	utils.DB.Where("group_id = ? and user_id = ?", groupId, userId).Find(&member)
+	return member
+}
+
+// groupMembersKey 成员缓存  与版本号用同一个hash tag 保证在同一个槽
+func groupMembersKey(groupId uint) string {
+	return "group_members_{" + strconv.Itoa(int(groupId)) + "}"
+}
+
+// groupMembersVerKey 成员缓存的版本号  成员每变化一次加一
+func groupMembersVerKey(groupId uint) string {
+	return "group_members_ver_{" + strconv.Itoa(int(groupId)) + "}"
+}
+
+// fillMembersScript 版本号没变时才写入缓存  避免读MySQL期间成员变化后把旧列表写回去
+var fillMembersScript = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+for i = 3, #ARGV, 1000 do
+	redis.call('SADD', KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// SearchUserByGroupId 群里所有成员的ID  优先读Redis缓存 缓存没有时从MySQL加载
+func SearchUserByGroupId(communityId uint) []uint {
+	ctx := context.Background()
+	key := groupMembersKey(communityId)
+	vals, err := utils.RedisCluster.SMembers(ctx, key).Result()
+	if err == nil && len(vals) > 0 {
+		objIds := make([]uint, 0, len(vals))
+		for _, v := range vals {
+			id, _ := strconv.Atoi(v)
+			objIds = append(objIds, uint(id))
+		}
+		return objIds
+	}
+	ver, err := utils.RedisCluster.Get(ctx, groupMembersVerKey(communityId)).Result()
+	if err == redis.Nil {
+		ver = "0"
+	}
+	objIds := make([]uint, 0)
+	utils.DB.Model(&GroupMember{}).Where("group_id = ?", communityId).Order("id").Pluck("user_id", &objIds)
+	if len(objIds) > 0 && (err == nil || err == redis.Nil) {
+		args := make([]interface{}, 0, len(objIds)+2)
+		args = append(args, ver, viper.GetInt("group.MemberCacheMinutes")*60)
+		for _, v := range objIds {
+			args = append(args, v)
+		}
+		err = fillMembersScript.Run(ctx, utils.RedisCluster, []string{key, groupMembersVerKey(communityId)}, args...).Err()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	return objIds
+}
+
+// invalidateGroupMembers 成员变化后清掉缓存并推进版本号  下次读取时重新加载
+func invalidateGroupMembers(groupId uint) {
+	ctx := context.Background()
+	pipe := utils.RedisCluster.TxPipeline()
+	pipe.Incr(ctx, groupMembersVerKey(groupId))
+	pipe.Del(ctx, groupMembersKey(groupId))
+	if _, err := pipe.Exec(ctx); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// IsGroupMember 用户是否在群里
+func IsGroupMember(userId uint, communityId uint) bool {
+	return FindGroupMember(communityId, userId).ID != 0
+}
+
+// IsGroupAdmin 是否为群管理者  群主和管理员
+func IsGroupAdmin(userId uint, communityId uint) bool {
+	return FindGroupMember(communityId, userId).Role >= RoleAdmin
+}
+
+// addGroupMember 写入群成员 已在群里时不重复写入
+func addGroupMember(tx *gorm.DB, groupId uint, userId uint, role int) error {
+	joinSeq, _ := utils.RedisCluster.Get(context.Background(), "seq_"+groupKey(groupId)).Int64()
+	member := GroupMember{GroupId: groupId, UserId: userId, Role: role, JoinedAt: time.Now(), JoinSeq: joinSeq}
+	return tx.Where("group_id = ? and user_id = ?", groupId, userId).FirstOrCreate(&member).Error
+}
+
+// removeGroupMember 删除群成员及其在群里的个人设置
+func removeGroupMember(groupId uint, userId uint) error {
+	err := utils.DB.Unscoped().Where("group_id = ? and user_id = ?", groupId, userId).Delete(&GroupMember{}).Error
+	if err != nil {
+		return err
+	}
+	invalidateGroupMembers(groupId)
+	utils.RedisCluster.SRem(context.Background(), groupMuteKey(groupId), userId)
+	return nil
+}
+
+// groupNotice 以操作人的名义向群里发一条系统消息
+func groupNotice(groupId uint, operatorId uint, content string) {
+	_, err := SendMsg(Message{
+		UserId:   int64(operatorId),
+		TargetId: int64(groupId),
+		Type:     2,
+		Media:    6,
+		Content:  content,
+	})
+	if err != nil {
+		fmt.Println("system msg send fail: ", err)
+	}
+}
+
+// LeaveGroup 退群  群主需要先转让群
+func LeaveGroup(userId uint, groupId uint) (int, string) {
+	member := FindGroupMember(groupId, userId)
+	if member.ID == 0 {
+		return -1, "不是群成员"
+	}
+	if member.Role == RoleOwner {
+		return -1, "群主请先转让群"
+	}
+	if findCommunity(groupId).Type != GroupChannel {
+		groupNotice(groupId, userId, FindByID(userId).Name+"退出了群聊")
+	}
+	if err := removeGroupMember(groupId, userId); err != nil {
+		return -1, "退群失败"
+	}
+	return 0, "退群成功"
+}
+
+// KickMember 移出群成员  只能移出角色比自己低的成员
+func KickMember(userId uint, groupId uint, targetId uint) (int, string) {
+	operator := FindGroupMember(groupId, userId)
+	target := FindGroupMember(groupId, targetId)
+	if target.ID == 0 {
+		return -1, "对方不是群成员"
+	}
+	if operator.Role < RoleAdmin || operator.Role <= target.Role {
+		return -1, "没有权限"
+	}
+	if err := removeGroupMember(groupId, targetId); err != nil {
+		return -1, "移出失败"
+	}
+	audit(groupId, userId, targetId, "kick", "")
+	groupNotice(groupId, userId, FindByID(targetId).Name+"被"+FindByID(userId).Name+"移出了群聊")
+	pushEvent(int64(targetId), "group_kick", map[string]interface{}{"GroupId": groupId, "userId": userId})
+	return 0, "移出成功"
+}
+
+// SetMemberRole 设置/取消管理员  仅群主可操作
+func SetMemberRole(userId uint, groupId uint, targetId uint, admin bool) (int, string) {
+	if FindGroupMember(groupId, userId).Role != RoleOwner {
+		return -1, "只有群主可以设置管理员"
+	}
+	target := FindGroupMember(groupId, targetId)
+	if target.ID == 0 {
+		return -1, "对方不是群成员"
+	}
+	if target.Role == RoleOwner {
+		return -1, "不能修改群主的角色"
+	}
+	role, action, content := RoleMember, "unset_admin", "取消了"+FindByID(targetId).Name+"的管理员"
+	if admin {
+		role, action, content = RoleAdmin, "set_admin", "将"+FindByID(targetId).Name+"设为管理员"
+	}
+	if target.Role == role {
+		return 0, "设置成功"
+	}
+	if err := utils.DB.Model(&target).Update("role", role).Error; err != nil {
+		return -1, "设置失败"
+	}
+	audit(groupId, userId, targetId, action, "")
+	groupNotice(groupId, userId, FindByID(userId).Name+content)
+	return 0, "设置成功"
+}
+
+// TransferOwner 转让群主  原群主变为管理员
+func TransferOwner(userId uint, groupId uint, targetId uint) (int, string) {
+	if userId == targetId {
+		return -1, "不能转让给自己"
+	}
+	if FindGroupMember(groupId, userId).Role != RoleOwner {
+		return -1, "只有群主可以转让群"
+	}
+	if !IsGroupMember(targetId, groupId) {
+		return -1, "对方不是群成员"
+	}
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		return transferOwner(tx, groupId, userId, targetId)
+	})
+	if err != nil {
+		return -1, "转让失败"
+	}
+	audit(groupId, userId, targetId, "transfer", "")
+	groupNotice(groupId, userId, FindByID(userId).Name+"将群主转让给了"+FindByID(targetId).Name)
+	return 0, "转让成功"
+}
+
+// transferOwner 修改群主  from 为0时只设置新群主
+func transferOwner(tx *gorm.DB, groupId uint, from uint, to uint) error {
+	if from != 0 {
+		if err := tx.Model(&GroupMember{}).Where("group_id = ? and user_id = ?", groupId, from).
+			Update("role", RoleAdmin).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&GroupMember{}).Where("group_id = ? and user_id = ?", groupId, to).
+		Update("role", RoleOwner).Error; err != nil {
+		return err
+	}
+	return tx.Model(&GroupBasic{}).Where("id = ?", groupId).Update("owner_id", to).Error
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+// GroupMemberInfo 群成员列表中的一项
+type GroupMemberInfo struct {
+	UserId   uint
+	Name     string
+	Avatar   string
+	Nickname string //群昵称
+	Role     int
+	JoinedAt time.Time
+	Online   bool //对方不允许查看在线状态时始终为false
+}
+
+// onlineStatus 批量查询在线状态  集群下按节点分组的 pipeline 避免跨槽
+func onlineStatus(userIds []uint) map[uint]bool {
+	res := make(map[uint]bool, len(userIds))
+	if len(userIds) == 0 {
+		return res
+	}
+	ctx := context.Background()
+	cmds := make([]*redis.IntCmd, len(userIds))
+	_, err := utils.RedisCluster.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range userIds {
+			cmds[i] = pipe.Exists(ctx, "online_"+strconv.Itoa(int(v)))
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	for i, v := range userIds {
+		res[v] = cmds[i].Val() > 0
+	}
+	return res
+}
+
+// LoadGroupMembers 分页查询群成员  群主和管理员排在前面 其余按入群时间
+// 频道只有管理员能查看订阅者
+func LoadGroupMembers(viewerId uint, groupId uint, page int, size int) ([]GroupMemberInfo, int64, int, string) {
+	res := make([]GroupMemberInfo, 0)
+	viewer := FindGroupMember(groupId, viewerId)
+	if viewer.ID == 0 {
+		return res, 0, -1, "不是群成员"
+	}
+	if viewer.Role < RoleAdmin && findCommunity(groupId).Type == GroupChannel {
+		return res, 0, -1, "只有频道管理员可以查看订阅者"
+	}
+	var total int64
+	db := utils.DB.Model(&GroupMember{}).Where("group_id = ?", groupId)
+	db.Count(&total)
+	members := make([]GroupMember, 0)
+	db.Order("role desc, joined_at, id").Offset((page - 1) * size).Limit(size).Find(&members)
+	if len(members) == 0 {
+		return res, total, 0, "ok"
+	}
+
+	userIds := make([]uint, 0, len(members))
+	for _, v := range members {
+		userIds = append(userIds, v.UserId)
+	}
+	users := make([]UserBasic, 0)
+	utils.DB.Unscoped().Where("id in ?", userIds).Find(&users)
+	userMap := make(map[uint]UserBasic, len(users))
+	for _, v := range users {
+		userMap[v.ID] = v
+	}
+	visible := CanSeeOnlineBatch(viewerId, userIds)
+	online := onlineStatus(userIds)
+	for _, v := range members {
+		user := userMap[v.UserId]
+		res = append(res, GroupMemberInfo{
+			UserId:   v.UserId,
+			Name:     user.Name,
+			Avatar:   user.Avatar,
+			Nickname: v.Nickname,
+			Role:     v.Role,
+			JoinedAt: v.JoinedAt,
+			Online:   visible[v.UserId] && online[v.UserId],
+		})
+	}
+	return res, total, 0, "ok"
+}
+
+func groupOnlineKey(groupId uint) string {
+	return "group_online_" + strconv.Itoa(int(groupId))
+}
+
+// GroupOnlineCount 群在线人数  结果短暂缓存 适合客户端轮询
+// 不统计不对所有人公开在线状态的成员 避免通过人数变化推断出个人的在线状态
+func GroupOnlineCount(userId uint, groupId uint) (int64, int, string) {
+	if !IsGroupMember(userId, groupId) {
+		return 0, -1, "不是群成员"
+	}
+	ctx := context.Background()
+	key := groupOnlineKey(groupId)
+	if count, err := utils.RedisCluster.Get(ctx, key).Int64(); err == nil {
+		return count, 0, "ok"
+	}
+	memberIds := SearchUserByGroupId(groupId)
+	hidden := make([]uint, 0)
+	if len(memberIds) > 0 {
+		utils.DB.Model(&PrivacySetting{}).Where("user_id in ? and online_status <> ?", memberIds, PrivacyEveryone).Pluck("user_id", &hidden)
+	}
+	hiddenMap := make(map[uint]bool, len(hidden))
+	for _, v := range hidden {
+		hiddenMap[v] = true
+	}
+	var count int64
+	for id, ok := range onlineStatus(memberIds) {
+		if ok && !hiddenMap[id] {
+			count++
+		}
+	}
+	utils.RedisCluster.Set(ctx, key, count, time.Duration(viper.GetInt("group.OnlineCountSeconds"))*time.Second)
+	return count, 0, "ok"
+}
+
+// SetGroupNickname 设置自己的群昵称  为空时清除
+func SetGroupNickname(userId uint, groupId uint, nickname string) (int, string) {
+	if utf8.RuneCountInString(nickname) > 32 {
+		return -1, "群昵称不能超过32个字"
+	}
+	res := utils.DB.Model(&GroupMember{}).Where("group_id = ? and user_id = ?", groupId, userId).Update("nickname", nickname)
+	if res.Error != nil {
+		return -1, "设置失败"
+	}
+	if res.RowsAffected == 0 && !IsGroupMember(userId, groupId) {
+		return -1, "不是群成员"
+	}
+	return 0, "设置成功"
+}
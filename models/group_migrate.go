@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// legacyCommunity 旧的群表 只在迁移时读取
+type legacyCommunity struct {
+	gorm.Model
+	Name         string
+	OwnerId      uint
+	Img          string
+	Desc         string
+	JoinPolicy   int
+	MaxMembers   int
+	MuteAll      bool
+	MemberInvite *bool
+	HideHistory  bool
+	SlowMode     int
+}
+
+func (table *legacyCommunity) TableName() string {
+	return "communities"
+}
+
+// MigrateGroups 将旧的 communities 表和群关系(contact type=2)迁移到 group_basic 和 group_member
+// 保留原来的群ID 会话key和群设置都不用改  communities 表不删除  迁移过的群关系软删除  可重复执行
+// group_basic 中已有同ID的其他群时不做任何迁移 直接返回错误 需要人工处理
+func MigrateGroups(db *gorm.DB) error {
+	if db.Migrator().HasTable(&legacyCommunity{}) {
+		communities := make([]legacyCommunity, 0)
+		if err := db.Unscoped().Order("id").Find(&communities).Error; err != nil {
+			return err
+		}
+		if err := checkGroupIdCollision(db, communities); err != nil {
+			return err
+		}
+		for _, v := range communities {
+			group := GroupBasic{
+				Name:         v.Name,
+				OwnerId:      v.OwnerId,
+				Icon:         v.Img,
+				Type:         GroupNormal,
+				Desc:         v.Desc,
+				JoinPolicy:   v.JoinPolicy,
+				MaxMembers:   v.MaxMembers,
+				MuteAll:      v.MuteAll,
+				MemberInvite: v.MemberInvite == nil || *v.MemberInvite,
+				HideHistory:  v.HideHistory,
+				SlowMode:     v.SlowMode,
+			}
+			group.Model = v.Model
+			res := db.Unscoped().Where("id = ?", v.ID).Attrs(group).FirstOrCreate(&GroupBasic{})
+			if res.Error != nil {
+				return res.Error
+			}
+			// member_invite 有默认值 false 需要单独写入
+			if res.RowsAffected > 0 && !group.MemberInvite {
+				db.Unscoped().Model(&GroupBasic{}).Where("id = ?", v.ID).Update("member_invite", false)
+			}
+		}
+	}
+	return migrateGroupMembers(db)
+}
+
+// checkGroupIdCollision 检查旧群的ID是否已被 group_basic 中的其他群占用
+// 同ID且群名 群主 创建时间一致的视为上次已迁移过的同一个群
+func checkGroupIdCollision(db *gorm.DB, communities []legacyCommunity) error {
+	ids := make([]uint, 0, len(communities))
+	for _, v := range communities {
+		ids = append(ids, v.ID)
+	}
+	existing := make([]GroupBasic, 0)
+	if err := db.Unscoped().Where("id in ?", ids).Find(&existing).Error; err != nil {
+		return err
+	}
+	groups := make(map[uint]GroupBasic, len(existing))
+	for _, v := range existing {
+		groups[v.ID] = v
+	}
+	conflicts := make([]string, 0)
+	for _, v := range communities {
+		g, ok := groups[v.ID]
+		if ok && (g.Name != v.Name || g.OwnerId != v.OwnerId || !g.CreatedAt.Equal(v.CreatedAt)) {
+			conflicts = append(conflicts, fmt.Sprintf("id %d: community %q / group_basic %q", v.ID, v.Name, g.Name))
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("group id collision, migration aborted: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// migrateGroupMembers 群关系迁移为群成员 并保证每个群主都在成员里
+// 迁移过的群关系在同一事务中软删除 之后启动不会把已退群或被踢的成员重新加回来
+func migrateGroupMembers(db *gorm.DB) error {
+	contacts := make([]Contact, 0)
+	if err := db.Where("type = 2").Order("id").Find(&contacts).Error; err != nil {
+		return err
+	}
+	owners := make(map[uint]uint)
+	groups := make([]GroupBasic, 0)
+	db.Find(&groups)
+	for _, v := range groups {
+		owners[v.ID] = v.OwnerId
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		migrated := make([]uint, 0, len(contacts))
+		for _, v := range contacts {
+			owner, ok := owners[v.TargetId]
+			if !ok {
+				continue
+			}
+			if err := migrateMember(tx, v.TargetId, v.OwnerId, owner, v.CreatedAt); err != nil {
+				return err
+			}
+			migrated = append(migrated, v.ID)
+		}
+		if len(migrated) == 0 {
+			return nil
+		}
+		return tx.Where("id in ?", migrated).Delete(&Contact{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range groups {
+		if err := migrateMember(db, v.ID, v.OwnerId, v.OwnerId, v.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return backfillJoinSeq(db)
+}
+
+// backfillJoinSeq 迁移来的成员没有入群序号 按入群时间之前群里最新的消息序号补上
+// 不补的话开启新成员不可见历史后 这些成员能看到全部历史
+func backfillJoinSeq(db *gorm.DB) error {
+	return db.Exec("update group_member gm set gm.join_seq = (" +
+		"select coalesce(max(m.seq), 0) from message m " +
+		"where m.type = 2 and m.thread_id = 0 and m.target_id = gm.group_id and m.created_at < gm.joined_at) " +
+		"where gm.join_seq = 0 and gm.deleted_at is null").Error
+}
+
+func migrateMember(db *gorm.DB, groupId uint, userId uint, ownerId uint, joinedAt time.Time) error {
+	role := RoleMember
+	if userId == ownerId {
+		role = RoleOwner
+	}
+	member := GroupMember{GroupId: groupId, UserId: userId, Role: role, JoinedAt: joinedAt}
+	return db.Where("group_id = ? and user_id = ?", groupId, userId).FirstOrCreate(&member).Error
+}
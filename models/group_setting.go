@@ -0,0 +1,216 @@
+package models
+
+import (
+	"GinChat/utils"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// GroupAnnouncement 群公告  置顶的公告需要每个成员确认已读
+type GroupAnnouncement struct {
+	gorm.Model
+	GroupId   uint
+	CreatorId uint
+	Content   string
+	Pinned    bool
+}
+
+func (table *GroupAnnouncement) TableName() string {
+	return "group_announcement"
+}
+
+// AnnouncementAck 成员确认已读公告
+type AnnouncementAck struct {
+	gorm.Model
+	AnnouncementId uint `gorm:"uniqueIndex:idx_announcement_ack"`
+	UserId         uint `gorm:"uniqueIndex:idx_announcement_ack"`
+}
+
+func (table *AnnouncementAck) TableName() string {
+	return "announcement_ack"
+}
+
+// AnnouncementInfo 公告列表项
+type AnnouncementInfo struct {
+	GroupAnnouncement
+	Acked    bool  //我是否已确认
+	AckCount int64 //已确认人数
+}
+
+// GroupSettings 群设置  为nil的项保持不变
+type GroupSettings struct {
+	MuteAll      *bool //全员禁言 管理员除外
+	MemberInvite *bool //普通成员可以邀请
+	HideHistory  *bool //新成员看不到入群前的消息
+	AllowComment *bool //频道允许评论
+	Public       *bool //公开到群搜索
+}
+
+// findCommunity 根据ID查群  不存在时ID为0
+func findCommunity(groupId uint) GroupBasic {
+	community := GroupBasic{}
+	utils.DB.Where("id = ?", groupId).Find(&community)
+	return community
+}
+
+// UpdateCommunity 修改群名称 图标和简介  只修改非空字段
+func UpdateCommunity(userId uint, groupId uint, name string, icon string, desc string) (int, string) {
+	community := findCommunity(groupId)
+	if community.ID == 0 {
+		return -1, "没有找到群"
+	}
+	if !IsGroupAdmin(userId, groupId) {
+		return -1, "只有群管理员可以修改"
+	}
+	updates := map[string]interface{}{}
+	if name != "" && name != community.Name {
+		if len([]rune(name)) > 30 {
+			return -1, "群名称不能超过30字"
+		}
+		updates["name"] = name
+	}
+	if icon != "" {
+		updates["icon"] = icon
+	}
+	if desc != "" {
+		updates["desc"] = desc
+	}
+	if len(updates) == 0 {
+		return 0, "修改成功"
+	}
+	if err := utils.DB.Model(&community).Updates(updates).Error; err != nil {
+		return -1, "修改失败"
+	}
+	if _, ok := updates["name"]; ok {
+		groupNotice(groupId, userId, FindByID(userId).Name+"将群名称修改为"+name)
+	}
+	groupEvent(groupId, "group_update", findCommunity(groupId))
+	return 0, "修改成功"
+}
+
+// SetGroupSettings 修改群设置  只修改传入的项
+func SetGroupSettings(userId uint, groupId uint, settings GroupSettings) (int, string) {
+	community := findCommunity(groupId)
+	if community.ID == 0 {
+		return -1, "没有找到群"
+	}
+	if !IsGroupAdmin(userId, groupId) {
+		return -1, "只有群管理员可以设置"
+	}
+	updates := map[string]interface{}{}
+	for column, v := range map[string]*bool{
+		"mute_all":      settings.MuteAll,
+		"member_invite": settings.MemberInvite,
+		"hide_history":  settings.HideHistory,
+		"allow_comment": settings.AllowComment,
+		"public":        settings.Public,
+	} {
+		if v != nil {
+			updates[column] = *v
+		}
+	}
+	if len(updates) == 0 {
+		return 0, "设置成功"
+	}
+	if err := utils.DB.Model(&community).Updates(updates).Error; err != nil {
+		return -1, "设置失败"
+	}
+	if settings.MuteAll != nil && *settings.MuteAll != community.MuteAll {
+		content := "关闭了全员禁言"
+		if *settings.MuteAll {
+			content = "开启了全员禁言"
+		}
+		audit(groupId, userId, 0, "mute_all", strconv.FormatBool(*settings.MuteAll))
+		groupNotice(groupId, userId, FindByID(userId).Name+content)
+	}
+	groupEvent(groupId, "group_update", findCommunity(groupId))
+	return 0, "设置成功"
+}
+
+// CreateAnnouncement 发布群公告  pin 为 true 时置顶并要求成员确认
+func CreateAnnouncement(userId uint, groupId uint, content string, pin bool) (GroupAnnouncement, int, string) {
+	announcement := GroupAnnouncement{GroupId: groupId, CreatorId: userId, Content: content, Pinned: pin}
+	if content == "" {
+		return announcement, -1, "公告内容不能为空"
+	}
+	if !IsGroupAdmin(userId, groupId) {
+		return announcement, -1, "只有群管理员可以发布公告"
+	}
+	if err := utils.DB.Create(&announcement).Error; err != nil {
+		return announcement, -1, "发布失败"
+	}
+	groupNotice(groupId, userId, FindByID(userId).Name+"发布了群公告："+content)
+	groupEvent(groupId, "group_announcement", announcement)
+	return announcement, 0, "发布成功"
+}
+
+// DeleteAnnouncement 删除群公告
+func DeleteAnnouncement(userId uint, announcementId uint) (int, string) {
+	announcement := GroupAnnouncement{}
+	utils.DB.Where("id = ?", announcementId).Find(&announcement)
+	if announcement.ID == 0 {
+		return -1, "公告不存在"
+	}
+	if !IsGroupAdmin(userId, announcement.GroupId) {
+		return -1, "只有群管理员可以删除公告"
+	}
+	utils.DB.Delete(&announcement)
+	utils.DB.Unscoped().Where("announcement_id = ?", announcement.ID).Delete(&AnnouncementAck{})
+	groupEvent(announcement.GroupId, "group_announcement_delete", announcement)
+	return 0, "删除成功"
+}
+
+// LoadAnnouncements 群公告列表  置顶的在前
+func LoadAnnouncements(userId uint, groupId uint) ([]AnnouncementInfo, int, string) {
+	res := make([]AnnouncementInfo, 0)
+	if !IsGroupMember(userId, groupId) {
+		return res, -1, "不是群成员"
+	}
+	announcements := make([]GroupAnnouncement, 0)
+	utils.DB.Where("group_id = ?", groupId).Order("pinned desc, id desc").Limit(50).Find(&announcements)
+	for _, v := range announcements {
+		info := AnnouncementInfo{GroupAnnouncement: v}
+		utils.DB.Model(&AnnouncementAck{}).Where("announcement_id = ?", v.ID).Count(&info.AckCount)
+		var acked int64
+		utils.DB.Model(&AnnouncementAck{}).Where("announcement_id = ? and user_id = ?", v.ID, userId).Count(&acked)
+		info.Acked = acked > 0
+		res = append(res, info)
+	}
+	return res, 0, "ok"
+}
+
+// AckAnnouncement 确认已读群公告
+func AckAnnouncement(userId uint, announcementId uint) (int, string) {
+	announcement := GroupAnnouncement{}
+	utils.DB.Where("id = ?", announcementId).Find(&announcement)
+	if announcement.ID == 0 {
+		return -1, "公告不存在"
+	}
+	if !IsGroupMember(userId, announcement.GroupId) {
+		return -1, "不是群成员"
+	}
+	ack := AnnouncementAck{AnnouncementId: announcementId, UserId: userId}
+	if err := utils.DB.Where(&ack).FirstOrCreate(&ack).Error; err != nil {
+		return -1, "确认失败"
+	}
+	return 0, "已确认"
+}
+
+// unackedAnnouncements 用户在各群中未确认的置顶公告数  groupId -> count
+func unackedAnnouncements(userId uint, groupIds []uint64) map[uint]int64 {
+	type row struct {
+		GroupId uint
+		Count   int64
+	}
+	rows := make([]row, 0)
+	acked := utils.DB.Model(&AnnouncementAck{}).Select("announcement_id").Where("user_id = ?", userId)
+	utils.DB.Model(&GroupAnnouncement{}).Select("group_id, count(*) as count").
+		Where("group_id in ? and pinned = ? and id not in (?)", groupIds, true, acked).
+		Group("group_id").Scan(&rows)
+	res := make(map[uint]int64)
+	for _, v := range rows {
+		res[v.GroupId] = v.Count
+	}
+	return res
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Mention 被@的记录  即用户的@我收件箱
+type Mention struct {
+	gorm.Model
+	UserId    uint //被@的用户
+	FromId    uint //谁@的
+	GroupId   uint //所在群
+	MessageId uint //对应的消息
+	IsRead    bool
+}
+
+func (table *Mention) TableName() string {
+	return "mention"
+}
+
+var mentionReg = regexp.MustCompile(`@(\S+)`)
+
+var errMentionAll = errors.New("只有群主和管理员可以@所有人")
+
+// ParseMentions 从消息内容中解析 @用户名 和 @all(@所有人)
+func ParseMentions(content string) (names []string, all bool) {
+	names = make([]string, 0)
+	for _, v := range mentionReg.FindAllStringSubmatch(content, -1) {
+		if v[1] == "all" || v[1] == "所有人" {
+			all = true
+			continue
+		}
+		names = append(names, v[1])
+	}
+	return names, all
+}
+
+// resolveMentions 整理群消息中的@对象  只保留群成员  非管理员@所有人时拒绝发送
+func resolveMentions(msg *Message) error {
+	// 频道不支持@ 避免给所有订阅者写数据  转发的内容不再触发@
+	if msg.Type != 2 || msg.ForwardMsgId != 0 || msg.Media == 5 || findCommunity(uint(msg.TargetId)).Type == GroupChannel {
+		msg.Mentions = nil
+		msg.MentionAll = false
+		return nil
+	}
+	names, all := ParseMentions(msg.Content)
+	msg.MentionAll = msg.MentionAll || all
+	if msg.MentionAll && !IsGroupAdmin(uint(msg.UserId), uint(msg.TargetId)) {
+		return errMentionAll
+	}
+	if len(names) == 0 && len(msg.Mentions) == 0 {
+		return nil
+	}
+	memberIds := SearchUserByGroupId(uint(msg.TargetId))
+	isMember := make(map[uint]bool)
+	for _, v := range memberIds {
+		isMember[v] = true
+	}
+	mentioned := make(map[uint]bool)
+	for _, v := range msg.Mentions {
+		if isMember[v] {
+			mentioned[v] = true
+		}
+	}
+	if len(names) != 0 {
+		users := make([]UserBasic, 0)
+		utils.DB.Where("id in ? and name in ?", memberIds, names).Find(&users)
+		for _, v := range users {
+			mentioned[v.ID] = true
+		}
+	}
+	delete(mentioned, uint(msg.UserId))
+	msg.Mentions = make([]uint, 0, len(mentioned))
+	for id := range mentioned {
+		msg.Mentions = append(msg.Mentions, id)
+	}
+	return nil
+}
+
+// notifyMentions 写入被@用户的收件箱并通知  免打扰的群同样通知
+func notifyMentions(msg Message) {
+	if msg.Type != 2 || (len(msg.Mentions) == 0 && !msg.MentionAll) {
+		return
+	}
+	userIds := msg.Mentions
+	if msg.MentionAll {
+		userIds = SearchUserByGroupId(uint(msg.TargetId))
+	}
+	mentions := make([]Mention, 0, len(userIds))
+	for _, v := range userIds {
+		if int64(v) == msg.UserId {
+			continue
+		}
+		mentions = append(mentions, Mention{
+			UserId:    v,
+			FromId:    uint(msg.UserId),
+			GroupId:   uint(msg.TargetId),
+			MessageId: msg.ID,
+		})
+	}
+	if len(mentions) == 0 {
+		return
+	}
+	if err := utils.DB.CreateInBatches(&mentions, 500).Error; err != nil {
+		fmt.Println("mention save fail: ", err)
+		return
+	}
+	ctx := context.Background()
+	groupIdStr := strconv.Itoa(int(msg.TargetId))
+	data := msgRef(msg)
+	data["Snippet"] = msgSnippet(msg)
+	for _, v := range mentions {
+		utils.RedisCluster.HIncrBy(ctx, mentionCountKey(v.UserId), groupIdStr, 1)
+		pushEvent(int64(v.UserId), "mention", data)
+	}
+}
+
+// mentionCountKey 用户在各群中未读的@数
+func mentionCountKey(userId uint) string {
+	return "mention_cnt_" + strconv.Itoa(int(userId))
+}
+
+// MentionCounts 用户在各群中未读的@数  groupId -> count
+func MentionCounts(userId uint) map[uint]int64 {
+	res := make(map[uint]int64)
+	counts, err := utils.RedisCluster.HGetAll(context.Background(), mentionCountKey(userId)).Result()
+	if err != nil {
+		fmt.Println(err)
+	}
+	for k, v := range counts {
+		groupId, _ := strconv.Atoi(k)
+		count, _ := strconv.ParseInt(v, 10, 64)
+		res[uint(groupId)] = count
+	}
+	return res
+}
+
+// LoadMentions @我的收件箱 按时间倒序分页
+func LoadMentions(userId uint, page int, size int) ([]Mention, int64) {
+	var total int64
+	mentions := make([]Mention, 0)
+	db := utils.DB.Model(&Mention{}).Where("user_id = ?", userId)
+	db.Count(&total)
+	db.Order("id desc").Offset((page - 1) * size).Limit(size).Find(&mentions)
+	return mentions, total
+}
+
+// ReadMentions 将某个群里@我的记录全部标为已读
+func ReadMentions(userId uint, groupId uint) {
+	utils.DB.Model(&Mention{}).Where("user_id = ? and group_id = ? and is_read = ?", userId, groupId, false).Update("is_read", true)
+	utils.RedisCluster.HDel(context.Background(), mentionCountKey(userId), strconv.Itoa(int(groupId)))
+}
+
+// groupMuteKey 对群开启了免打扰的用户
+func groupMuteKey(groupId uint) string {
+	return "group_mute_" + strconv.Itoa(int(groupId))
+}
+
+// SetGroupMute 设置群免打扰  免打扰只影响普通消息提醒 @消息照常通知
+func SetGroupMute(userId uint, groupId uint, mute bool) (int, string) {
+	if !IsGroupMember(userId, groupId) {
+		return -1, "不是群成员"
+	}
+	ctx := context.Background()
+	var err error
+	if mute {
+		err = utils.RedisCluster.SAdd(ctx, groupMuteKey(groupId), userId).Err()
+	} else {
+		err = utils.RedisCluster.SRem(ctx, groupMuteKey(groupId), userId).Err()
+	}
+	if err != nil {
+		return -1, "设置失败"
+	}
+	return 0, "设置成功"
+}
+
+// silentMembers 对这条群消息不提醒的成员  开启了免打扰且没有被@
+func silentMembers(msg Message) map[int64]bool {
+	if msg.MentionAll {
+		return nil
+	}
+	vals, err := utils.RedisCluster.SMembers(context.Background(), groupMuteKey(uint(msg.TargetId))).Result()
+	if err != nil || len(vals) == 0 {
+		return nil
+	}
+	res := make(map[int64]bool, len(vals))
+	for _, v := range vals {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		res[id] = true
+	}
+	for _, v := range msg.Mentions {
+		delete(res, int64(v))
+	}
+	return res
+}
+
+// IsGroupMuted 用户是否对群开启了免打扰
+func IsGroupMuted(userId uint, groupId uint) bool {
+	muted, _ := utils.RedisCluster.SIsMember(context.Background(), groupMuteKey(groupId), userId).Result()
+	return muted
+}
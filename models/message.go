@@ -4,6 +4,7 @@ import (
 	"GinChat/utils"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -21,17 +22,31 @@ import (
 // Message 消息
 type Message struct {
 	gorm.Model
-	UserId     int64  //发送者
-	TargetId   int64  //接受者
-	Type       int    //发送类型  1私聊  2群聊  3心跳
-	Media      int    //消息类型  1文字 2表情包 3语音 4图片 /表情包
-	Content    string //消息内容
-	CreateTime uint64 //创建时间
-	ReadTime   uint64 //读取时间
-	Pic        string
-	Url        string
-	Desc       string
-	Amount     int //其他数字统计
+	UserId       int64  `json:"userId"`                                //发送者
+	TargetId     int64  `gorm:"index:idx_message_conv_seq,priority:2"` //接受者
+	Type         int    `gorm:"index:idx_message_conv_seq,priority:1"` //发送类型  1私聊  2群聊  3心跳  4事件通知
+	Media        int    //消息类型  1文字 2表情包 3语音 4图片 /表情包 5合并转发 6系统消息
+	Content      string //消息内容
+	CreateTime   uint64 //创建时间
+	ReadTime     uint64 //读取时间
+	Pic          string
+	Url          string `json:"url"`
+	Desc         string
+	Amount       int                  `json:"amount"`                                                                        //其他数字统计
+	Seq          int64                `gorm:"index:idx_message_conv_seq,priority:3;index:idx_message_thread_seq,priority:2"` //会话内序号 也是缓存中的排序分数
+	ParentId     uint                 `gorm:"index"`                                                                         //回复的消息ID
+	Quote        string               //被回复消息的引用片段
+	ThreadId     uint                 `gorm:"index:idx_message_thread_seq,priority:1"` //所属话题 0为群主时间线
+	Mentions     []uint               `gorm:"serializer:json"`                         //群聊中@的用户
+	MentionAll   bool                 //是否@所有人
+	ForwardFrom  int64                //转发消息的原发送者
+	ForwardMsgId uint                 //转发的原消息
+	DisappearTTL int                  //阅后即焚秒数 0不焚毁
+	ExpireAt     uint64               //焚毁时间 0表示尚未开始计时
+	Archive      string               `gorm:"size:255" json:",omitempty"` //内容所在的归档文件 为空表示未归档
+	Reactions    map[string]*Reaction `gorm:"-" json:",omitempty"`        //表情回应 仅在历史消息中返回
+	Silent       bool                 `gorm:"-" json:",omitempty"`        //免打扰 投递给开启了免打扰的成员时标记 客户端不提醒
+	ScheduleId   int64                `gorm:"-" json:"-"`                 //定时任务ID 防止租约过期后重复发送
 }
 
 func (table *Message) TableName() string {
@@ -39,6 +54,7 @@ func (table *Message) TableName() string {
 }
 
 type Node struct {
+	UserId        int64           //连接的用户
 	Conn          *websocket.Conn //连接
 	Addr          string          //客户端地址
 	FirstTime     uint64          //首次连接时间
@@ -76,6 +92,7 @@ func Chat(writer http.ResponseWriter, request *http.Request) {
 	//2.获取conn
 	currentTime := uint64(time.Now().Unix())
 	node := &Node{
+		UserId:        userId,
 		Conn:          conn,
 		Addr:          conn.RemoteAddr().String(), //客户端地址
 		HeartbeatTime: currentTime,                //心跳时间
@@ -113,11 +130,37 @@ func sendProc(node *Node) {
 	}
 }
 
+// offline 连接断开  从连接映射中移除并清掉在线状态  同一用户已建立新连接时不影响新连接
+func (node *Node) offline() {
+	rwLocker.Lock()
+	current, ok := clientMap[node.UserId]
+	if ok && current == node {
+		delete(clientMap, node.UserId)
+	}
+	rwLocker.Unlock()
+	if ok && current == node {
+		ClearUserOnlineInfo("online_"+strconv.Itoa(int(node.UserId)), []byte(node.Addr))
+	}
+}
+
+// enqueue 把消息放入连接的发送队列  队列已满说明客户端消费太慢 断开连接让它重连后从时间线拉取
+// 不阻塞投递协程 避免一个慢连接拖住整个群的投递
+func (node *Node) enqueue(data []byte) {
+	select {
+	case node.DataQueue <- data:
+	default:
+		fmt.Println("send queue full, disconnect: ", node.UserId)
+		node.Conn.Close()
+	}
+}
+
 func recvProc(node *Node) {
 	for {
 		_, data, err := node.Conn.ReadMessage()
 		if err != nil {
 			fmt.Println(err)
+			// 客户端断开或心跳超时被 CleanConnection 关闭
+			node.offline()
 			return
 		}
 		msg := Message{}
@@ -130,6 +173,18 @@ func recvProc(node *Node) {
 			currentTime := uint64(time.Now().Unix())
 			node.Heartbeat(currentTime)
 		} else {
+			// 先落库拿到稳定的消息ID 再投递
+			data, err = saveMsg(node, data)
+			if err != nil {
+				fmt.Println("message save fail: ", err)
+				// 被禁言等原因发送失败时告诉发送者
+				if errors.Is(err, errMuted) || errors.Is(err, errMuteAll) || errors.Is(err, errSlowMode) ||
+					errors.Is(err, errNotMember) || errors.Is(err, errReadOnly) || errors.Is(err, errNoComment) ||
+					errors.Is(err, errMentionAll) {
+					pushEvent(node.UserId, "msg_reject", map[string]interface{}{"TargetId": msg.TargetId, "Reason": err.Error()})
+				}
+				continue
+			}
 			dispatch(data)
 			broadMsg(data) //todo 将消息广播到局域网
 			fmt.Println("[ws]recvProc <<<< ", string(data))
@@ -193,15 +248,19 @@ func udpRecvProc() {
 			fmt.Println(err)
 		}
 	}(con)
+	// 按UDP报文的最大长度读取 避免较长的消息被截断
+	buf := make([]byte, 65535)
 	for {
-		var buf [512]byte
-		n, err := con.Read(buf[0:])
+		n, err := con.Read(buf)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 		fmt.Println("udpRecvProc  data :", string(buf[0:n]))
-		dispatch(buf[0:n])
+		// 投递是异步的 每条消息单独拷贝一份
+		data := make([]byte, n)
+		copy(data, buf[0:n])
+		dispatch(data)
 	}
 }
 
@@ -220,40 +279,251 @@ func dispatch(data []byte) {
 		sendMsg(msg.TargetId, data)
 	case 2: //群发
 		sendGroupMsg(msg.TargetId, data) //发送的群ID ，消息内容
+	case 4: //事件通知 只投递给本节点上的连接
+		ev := Event{}
+		if json.Unmarshal(data, &ev) == nil && ev.GroupId != 0 {
+			deliverGroup(uint(ev.GroupId), data, nil, nil)
+		} else {
+			sendLocal(msg.TargetId, data)
+		}
 	}
 }
 
-func sendGroupMsg(targetId int64, msg []byte) {
-	fmt.Println("开始群发消息")
-	userIds := SearchUserByGroupId(uint(targetId))
-	for i := 0; i < len(userIds); i++ {
-		//排除给自己的
-		if targetId != int64(userIds[i]) {
-			sendMsg(int64(userIds[i]), msg)
+// saveMsg 消息写入MySQL 返回带ID的消息内容  发送者以连接的用户为准
+func saveMsg(node *Node, data []byte) ([]byte, error) {
+	msg := Message{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg.UserId != node.UserId {
+		return nil, fmt.Errorf("sender %d does not match connection user %d", msg.UserId, node.UserId)
+	}
+	// 客户端只能发私聊和群聊 事件通知只由服务端产生
+	if msg.Type != 1 && msg.Type != 2 {
+		return nil, fmt.Errorf("unsupported message type %d", msg.Type)
+	}
+	// 转发相关字段只能由服务端填写
+	msg.ForwardFrom = 0
+	msg.ForwardMsgId = 0
+	if msg.Media == 5 || msg.Media == 6 {
+		return nil, fmt.Errorf("media %d must be sent by server", msg.Media)
+	}
+	if err = storeMsg(&msg); err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+// storeMsg 补全序号 引用 @等信息后写入MySQL
+func storeMsg(msg *Message) error {
+	var err error
+	msg.CreateTime = uint64(time.Now().Unix())
+	if msg.ParentId != 0 {
+		parent, err := FindMsgByID(msg.ParentId)
+		if err != nil || !IsMsgVisible(uint(msg.UserId), parent) {
+			return fmt.Errorf("reply to invisible message %d", msg.ParentId)
+		}
+		// 只能引用同一会话里的消息 避免把群消息引用到私聊里泄露给群外的人
+		if chatKey(parent) != chatKey(*msg) {
+			return fmt.Errorf("reply to message %d from another conversation", msg.ParentId)
+		}
+		msg.Quote = msgSnippet(parent)
+	}
+	if msg.ThreadId != 0 {
+		thread := FindThreadByID(msg.ThreadId)
+		if thread.ID == 0 || msg.Type != 2 || int64(thread.GroupId) != msg.TargetId {
+			return fmt.Errorf("thread %d not in group %d", msg.ThreadId, msg.TargetId)
+		}
+	}
+	if msg.Type == 1 && IsBlocked(uint(msg.TargetId), uint(msg.UserId)) {
+		return fmt.Errorf("user %d is blocked by %d", msg.UserId, msg.TargetId)
+	}
+	if msg.Type == 2 && msg.Media != 6 {
+		if err = checkGroupSend(uint(msg.UserId), uint(msg.TargetId), msg.ThreadId); err != nil {
+			return err
+		}
+	}
+	if err = resolveMentions(msg); err != nil {
+		return err
+	}
+	if msg.ScheduleId != 0 {
+		if err = markScheduleSent(msg.ScheduleId); err != nil {
+			return err
+		}
+	}
+	applyDisappear(msg)
+	key := convKey(*msg)
+	msg.Seq, err = nextSeq(key)
+	if err == nil {
+		err = utils.DB.Create(msg).Error
+	}
+	if err != nil {
+		if msg.ScheduleId != 0 {
+			unmarkScheduleSent(msg.ScheduleId)
 		}
+		return err
+	}
+	// 群消息只在群时间线存一份 话题消息只进话题时间线 都不进成员的会话缓存
+	if msg.ThreadId != 0 || msg.Type == 2 {
+		utils.RedisCluster.ZAdd(context.Background(), key, redis.Z{Score: float64(msg.Seq), Member: *msg})
+	}
+	if msg.ThreadId != 0 {
+		utils.DB.Model(&Thread{}).Where("id = ?", msg.ThreadId).Update("updated_at", time.Now())
+	}
+	// @所有人要给全群写记录 放到后台 不拖慢发送
+	if msg.MentionAll {
+		go notifyMentions(*msg)
+	} else {
+		notifyMentions(*msg)
+	}
+	scheduleExpire(*msg)
+	if err = msgIndex.Index(*msg); err != nil {
+		fmt.Println("message index fail: ", err)
+	}
+	return nil
+}
+
+// SendMsg 服务端代发消息  与客户端消息走同样的落库和投递流程
+func SendMsg(msg Message) (Message, error) {
+	if err := storeMsg(&msg); err != nil {
+		return msg, err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return msg, err
+	}
+	dispatch(data)
+	broadMsg(data)
+	return msg, nil
+}
 
+// convKey 消息所属会话  私聊为双方缓存key 群聊为群 话题为话题自己的时间线
+func convKey(msg Message) string {
+	if msg.ThreadId != 0 {
+		return threadKey(msg.ThreadId)
 	}
+	if msg.Type == 2 {
+		return groupKey(uint(msg.TargetId))
+	}
+	return msgKey(msg.UserId, msg.TargetId)
+}
+
+// nextSeq 会话内自增序号  首次使用时从已有缓存条数接着编号
+func nextSeq(key string) (int64, error) {
+	ctx := context.Background()
+	seqKey := "seq_" + key
+	exists, err := utils.RedisCluster.Exists(ctx, seqKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		count, _ := utils.RedisCluster.ZCard(ctx, key).Result()
+		utils.RedisCluster.SetNX(ctx, seqKey, count, 0)
+	}
+	return utils.RedisCluster.Incr(ctx, seqKey).Result()
 }
 
-// JoinGroup 加入群聊
-func JoinGroup(userId uint, comIdOrName string) (int, string) {
-	contact := Contact{}
-	contact.OwnerId = userId
-	contact.Type = 2
-	community := Community{}
+// msgSnippet 消息的简短摘要 用于引用回复
+func msgSnippet(msg Message) string {
+	if msg.DeletedAt.Valid {
+		return "[消息已删除]"
+	}
+	switch msg.Media {
+	case 2:
+		return "[表情]"
+	case 3:
+		return "[语音]"
+	case 4:
+		return "[图片]"
+	}
+	content := []rune(msg.Content)
+	if len(content) > 30 {
+		return string(content[:30]) + "..."
+	}
+	return string(content)
+}
 
-	utils.DB.Where("id=? or name=?", comIdOrName, comIdOrName).Find(&community)
-	if community.Name == "" {
-		return -1, "没有找到群"
+// sendLocal 投递给本节点上在线的用户
+func sendLocal(userId int64, data []byte) {
+	rwLocker.RLock()
+	node, ok := clientMap[userId]
+	rwLocker.RUnlock()
+	if ok {
+		node.enqueue(data)
 	}
-	utils.DB.Where("owner_id=? and target_id=? and type =2 ", userId, community.ID).Find(&contact)
-	if !contact.CreatedAt.IsZero() {
-		return -1, "已加过此群"
+}
+
+// sendGroupMsg 群发  消息落库时已写入群时间线 这里只投递给本节点在线的成员 离线成员上线后从时间线拉取
+func sendGroupMsg(targetId int64, msg []byte) {
+	fmt.Println("开始群发消息")
+	jsonMsg := Message{}
+	if err := json.Unmarshal(msg, &jsonMsg); err != nil || !groupSendAllowed(jsonMsg) {
+		fmt.Println("group message rejected: ", string(msg))
+		return
+	}
+	// 开启免打扰的成员收到带 Silent 标记的消息  被@的成员照常提醒
+	var silent []byte
+	muted := silentMembers(jsonMsg)
+	if len(muted) > 0 {
+		jsonMsg.Silent = true
+		silent, _ = json.Marshal(jsonMsg)
+	}
+	deliverGroup(uint(targetId), msg, silent, muted)
+}
+
+// deliverGroup 分批并发投递给本节点上连接着的群成员  silentFor 中的成员收到 silent
+// 每个节点都会收到广播 只需处理自己的连接 不用逐个成员查在线状态
+func deliverGroup(groupId uint, data []byte, silent []byte, silentFor map[int64]bool) {
+	members := SearchUserByGroupId(groupId)
+	nodes := make([]*Node, 0)
+	ids := make([]int64, 0)
+	rwLocker.RLock()
+	if len(clientMap) < len(members) {
+		memberSet := make(map[int64]bool, len(members))
+		for _, v := range members {
+			memberSet[int64(v)] = true
+		}
+		for id, node := range clientMap {
+			if memberSet[id] {
+				nodes = append(nodes, node)
+				ids = append(ids, id)
+			}
+		}
 	} else {
-		contact.TargetId = community.ID
-		utils.DB.Create(&contact)
-		return 0, "加群成功"
+		for _, v := range members {
+			if node, ok := clientMap[int64(v)]; ok {
+				nodes = append(nodes, node)
+				ids = append(ids, int64(v))
+			}
+		}
 	}
+	rwLocker.RUnlock()
+
+	batch := viper.GetInt("group.FanoutBatch")
+	if batch <= 0 {
+		batch = 500
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < len(nodes); i += batch {
+		end := i + batch
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		wg.Add(1)
+		go func(part []*Node, partIds []int64) {
+			defer wg.Done()
+			for j, node := range part {
+				if silentFor[partIds[j]] {
+					node.enqueue(silent)
+				} else {
+					node.enqueue(data)
+				}
+			}
+		}(nodes[i:end], ids[i:end])
+	}
+	// 等本条投递完再处理下一条 保证同一个连接上的消息顺序
+	wg.Wait()
 }
 
 func sendMsg(userId int64, msg []byte) {
@@ -267,8 +537,11 @@ func sendMsg(userId int64, msg []byte) {
 		fmt.Println("message unmarshal fail: ", err)
 		return
 	}
+	// 被对方拉黑时私信不投递  其他节点广播过来的消息也在这里拦截
+	if jsonMsg.Type == 1 && IsBlocked(uint(userId), uint(jsonMsg.UserId)) {
+		return
+	}
 	ctx := context.Background()
-	targetIdStr := strconv.Itoa(int(userId))
 	userIdStr := strconv.Itoa(int(jsonMsg.UserId))
 	jsonMsg.CreateTime = uint64(time.Now().Unix())
 	r, err := utils.RedisCluster.Get(ctx, "online_"+userIdStr).Result()
@@ -278,27 +551,19 @@ func sendMsg(userId int64, msg []byte) {
 	if r != "" {
 		if ok {
 			fmt.Println("sendMsg >>> userID: ", userId, "  msg:", string(msg))
-			node.DataQueue <- msg
+			node.enqueue(msg)
 		}
 	}
 
-	// build redis key
-	var key string
-	if userId > jsonMsg.UserId {
-		key = "msg_" + userIdStr + "_" + targetIdStr
-	} else {
-		key = "msg_" + targetIdStr + "_" + userIdStr
-	}
-
-	// 降序获取所有 key 下的历史 msg
-	msgs, err := utils.RedisCluster.ZRevRange(ctx, key, 0, -1).Result()
-	if err != nil {
-		fmt.Println(err)
+	// 群消息和话题消息已经写入各自的时间线
+	if jsonMsg.ThreadId != 0 || jsonMsg.Type == 2 {
+		return
 	}
+	key := msgKey(userId, jsonMsg.UserId)
 
-	// 加入新的msg，并让其排序最大
+	// 以会话序号作为分数 重复投递时分数不变
+	score := float64(jsonMsg.Seq)
 	// 新增的元素个数（1 = 新增、0 = 仅更新分数）
-	score := float64(cap(msgs)) + 1
 	res, err := utils.RedisCluster.ZAdd(ctx, key, redis.Z{Score: score, Member: msg}).Result() //jsonMsg
 	//msgs, e := utils.RedisClient.Do(ctx, "zadd", key, 1, jsonMsg).Result() //备用 后续拓展 记录完整msg
 	if err != nil {
@@ -324,14 +589,7 @@ func RedisMsg(userIdA int64, userIdB int64, start int64, end int64, isRev bool)
 	//jsonMsg := Message{}
 	//json.Unmarshal(msg, &jsonMsg)
 	ctx := context.Background()
-	userIdStr := strconv.Itoa(int(userIdA))
-	targetIdStr := strconv.Itoa(int(userIdB))
-	var key string
-	if userIdA > userIdB {
-		key = "msg_" + targetIdStr + "_" + userIdStr
-	} else {
-		key = "msg_" + userIdStr + "_" + targetIdStr
-	}
+	key := msgKey(userIdA, userIdB)
 
 	// 获取Redis查到的消息列表rels
 	var rels []string
@@ -344,8 +602,19 @@ func RedisMsg(userIdA int64, userIdB int64, start int64, end int64, isRev bool)
 	if err != nil {
 		fmt.Println(err) //没有找到
 	}
+	if !isRev {
+		rels = olderFromMySQL(userIdA, userIdB, start, end, rels)
+	}
+
+	return attachReactions(filterHiddenMsg(uint(userIdA), rels))
+}
 
-	return rels
+// msgKey 两个用户之间的消息缓存key  小ID在前
+func msgKey(userIdA int64, userIdB int64) string {
+	if userIdA > userIdB {
+		userIdA, userIdB = userIdB, userIdA
+	}
+	return "msg_" + strconv.Itoa(int(userIdA)) + "_" + strconv.Itoa(int(userIdB))
 }
 
 // Heartbeat 更新用户心跳
@@ -363,6 +632,9 @@ func CleanConnection() (result bool) {
 		}
 	}()
 	currentTime := uint64(time.Now().Unix())
+	// 关闭后 recvProc 会把连接从 clientMap 中移除 遍历时要持有读锁
+	rwLocker.RLock()
+	defer rwLocker.RUnlock()
 	for i := range clientMap {
 		node := clientMap[i]
 		if node.IsHeartbeatTimeOut(currentTime) {
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MessageHidden 仅对自己删除（隐藏）的消息记录
+type MessageHidden struct {
+	gorm.Model
+	UserId    uint `gorm:"uniqueIndex:idx_message_hidden_user_message"` //谁隐藏的
+	MessageId uint `gorm:"uniqueIndex:idx_message_hidden_user_message"` //被隐藏的消息
+}
+
+func (table *MessageHidden) TableName() string {
+	return "message_hidden"
+}
+
+// FindMsgByID 根据ID查找消息 包含已删除的消息
+func FindMsgByID(msgId uint) (Message, error) {
+	msg := Message{}
+	err := utils.DB.Unscoped().Where("id = ?", msgId).First(&msg).Error
+	return msg, err
+}
+
+// IsMsgVisible 用户是否能看到这条消息  私聊为收发双方 群聊为群成员 群隐藏历史时只能看到入群后的消息
+func IsMsgVisible(userId uint, msg Message) bool {
+	if msg.UserId == int64(userId) {
+		return true
+	}
+	switch msg.Type {
+	case 1:
+		return msg.TargetId == int64(userId)
+	case 2:
+		return canSeeGroupMsg(userId, msg)
+	}
+	return false
+}
+
+// msgEvent 把消息相关的事件推送给会话里的所有人  群消息整群广播一次 私聊推给双方
+func msgEvent(msg Message, event string, data interface{}) {
+	if msg.Type == 2 {
+		groupEvent(uint(msg.TargetId), event, data)
+		return
+	}
+	pushEvent(msg.UserId, event, data)
+	if msg.TargetId != msg.UserId {
+		pushEvent(msg.TargetId, event, data)
+	}
+}
+
+// msgCacheKeys 消息所在的缓存key  私聊为双方的会话 群聊和话题为各自的时间线
+func msgCacheKeys(msg Message) []string {
+	return []string{convKey(msg)}
+}
+
+// DeleteMsgForMe 仅对自己删除 只写入隐藏标记
+func DeleteMsgForMe(userId uint, msgId uint) (int, string) {
+	msg, err := FindMsgByID(msgId)
+	if err != nil {
+		return -1, "消息不存在"
+	}
+	if !IsMsgVisible(userId, msg) {
+		return -1, "无权删除此消息"
+	}
+	// 重复删除时唯一索引冲突 直接忽略
+	hidden := MessageHidden{UserId: userId, MessageId: msgId}
+	if err := utils.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&hidden).Error; err != nil {
+		return -1, "删除失败"
+	}
+	pushEvent(int64(userId), "msg_hide", msgRef(msg))
+	return 0, "删除成功"
+}
+
+// DeleteMsgForAll 对所有人删除 MySQL软删除 缓存里只保留墓碑  群管理员可删除成员的消息
+func DeleteMsgForAll(userId uint, msgId uint) (int, string) {
+	msg := Message{}
+	utils.DB.Where("id = ?", msgId).Find(&msg)
+	if msg.ID == 0 {
+		return -1, "消息不存在或已删除"
+	}
+	// 群管理员可以删除成员的消息
+	moderated := msg.UserId != int64(userId)
+	if moderated && (msg.Type != 2 || !canModerate(userId, uint(msg.TargetId), uint(msg.UserId))) {
+		return -1, "只能删除自己发送的消息"
+	}
+	if err := utils.DB.Delete(&msg).Error; err != nil {
+		return -1, "删除失败"
+	}
+	msg.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	tombstone := msgTombstone(msg)
+	for _, key := range msgCacheKeys(msg) {
+		replaceCachedMsg(key, msg.ID, msg.Seq, &tombstone)
+	}
+	clearReactions(msg.ID)
+	msgIndex.Remove(msg.ID)
+	msgEvent(msg, "msg_delete", msgRef(msg))
+	if moderated {
+		audit(uint(msg.TargetId), userId, uint(msg.UserId), "delete_msg", msgSnippet(msg))
+	}
+	return 0, "删除成功"
+}
+
+// msgTombstone 删除后的占位消息 不再携带任何内容
+func msgTombstone(msg Message) Message {
+	tombstone := Message{
+		UserId:     msg.UserId,
+		TargetId:   msg.TargetId,
+		Type:       msg.Type,
+		CreateTime: msg.CreateTime,
+	}
+	tombstone.ID = msg.ID
+	tombstone.CreatedAt = msg.CreatedAt
+	tombstone.DeletedAt = msg.DeletedAt
+	return tombstone
+}
+
+// msgRef 事件中只携带定位消息所需的字段
+func msgRef(msg Message) map[string]interface{} {
+	return map[string]interface{}{
+		"ID":       msg.ID,
+		"Type":     msg.Type,
+		"userId":   msg.UserId,
+		"TargetId": msg.TargetId,
+	}
+}
+
+// replaceCachedMsg 将缓存中指定ID的消息替换成新内容 分数保持不变  newMsg 为nil时直接移除
+// 缓存分数就是会话序号 按序号定位 不用扫描整个会话
+func replaceCachedMsg(key string, msgId uint, seq int64, newMsg *Message) {
+	ctx := context.Background()
+	score := strconv.FormatInt(seq, 10)
+	zs, err := utils.RedisCluster.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: score, Max: score}).Result()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, z := range zs {
+		member, _ := z.Member.(string)
+		cached := Message{}
+		if json.Unmarshal([]byte(member), &cached) != nil || cached.ID != msgId {
+			continue
+		}
+		utils.RedisCluster.ZRem(ctx, key, member)
+		if newMsg != nil {
+			utils.RedisCluster.ZAdd(ctx, key, redis.Z{Score: z.Score, Member: *newMsg})
+		}
+		return
+	}
+}
+
+// filterHiddenMsg 过滤掉用户自己隐藏的消息
+func filterHiddenMsg(userId uint, rels []string) []string {
+	msgs := make([]Message, len(rels))
+	ids := make([]uint, 0)
+	for i, v := range rels {
+		if json.Unmarshal([]byte(v), &msgs[i]) == nil && msgs[i].ID != 0 {
+			ids = append(ids, msgs[i].ID)
+		}
+	}
+	if len(ids) == 0 {
+		return rels
+	}
+	hiddenIds := make([]uint, 0)
+	utils.DB.Model(&MessageHidden{}).Where("user_id = ? and message_id in ?", userId, ids).Pluck("message_id", &hiddenIds)
+	if len(hiddenIds) == 0 {
+		return rels
+	}
+	hidden := make(map[uint]bool)
+	for _, v := range hiddenIds {
+		hidden[v] = true
+	}
+	res := make([]string, 0, len(rels))
+	for i, v := range rels {
+		if !hidden[msgs[i].ID] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GroupAuditLog 群管理操作记录  仅管理员可见
+type GroupAuditLog struct {
+	gorm.Model
+	GroupId    uint
+	OperatorId uint   //操作人
+	TargetId   uint   //被操作的成员 没有时为0
+	Action     string //mute unmute delete_msg slow_mode mute_all kick set_admin unset_admin transfer
+	Detail     string
+}
+
+func (table *GroupAuditLog) TableName() string {
+	return "group_audit_log"
+}
+
+// 发言被拒绝的原因  会推送给发送者
+var (
+	errNotMember = errors.New("不是群成员")
+	errMuteAll   = errors.New("全员禁言中")
+	errMuted     = errors.New("你已被禁言")
+	errSlowMode  = errors.New("慢速模式下发言过快")
+	errReadOnly  = errors.New("只有频道管理员可以发布")
+	errNoComment = errors.New("频道未开启评论")
+)
+
+// audit 记录群管理操作
+func audit(groupId uint, operatorId uint, targetId uint, action string, detail string) {
+	utils.DB.Create(&GroupAuditLog{GroupId: groupId, OperatorId: operatorId, TargetId: targetId, Action: action, Detail: detail})
+}
+
+// canModerate operatorId 能否管理 targetId  管理员管理普通成员 群主管理所有人
+func canModerate(operatorId uint, groupId uint, targetId uint) bool {
+	operator := FindGroupMember(groupId, operatorId)
+	if operator.Role < RoleAdmin {
+		return false
+	}
+	return operatorId == targetId || operator.Role > FindGroupMember(groupId, targetId).Role
+}
+
+func slowModeKey(groupId uint, userId uint) string {
+	return "slow_" + strconv.Itoa(int(groupId)) + "_" + strconv.Itoa(int(userId))
+}
+
+// checkGroupSend 能否在群里发言  检查成员身份 频道只读 全员禁言 个人禁言和慢速模式
+// 慢速模式会占用一次发言机会 只在消息落库前调用
+func checkGroupSend(userId uint, groupId uint, threadId uint) error {
+	member := FindGroupMember(groupId, userId)
+	if member.ID == 0 {
+		return errNotMember
+	}
+	if member.Role >= RoleAdmin {
+		return nil
+	}
+	if member.MuteUntil > time.Now().Unix() {
+		return errMuted
+	}
+	community := findCommunity(groupId)
+	if community.Type == GroupChannel {
+		if threadId == 0 {
+			return errReadOnly
+		}
+		if !community.AllowComment {
+			return errNoComment
+		}
+	}
+	if community.MuteAll {
+		return errMuteAll
+	}
+	if community.SlowMode > 0 {
+		ok, err := utils.RedisCluster.SetNX(context.Background(), slowModeKey(groupId, userId), 1,
+			time.Duration(community.SlowMode)*time.Second).Result()
+		if err == nil && !ok {
+			return errSlowMode
+		}
+	}
+	return nil
+}
+
+// groupSendAllowed 群发前复查  消息必须已经落库(落库时已检查慢速模式) 发送者仍在群里且没有被禁言
+func groupSendAllowed(msg Message) bool {
+	if msg.ID == 0 {
+		return false
+	}
+	if msg.Media == 6 {
+		return true
+	}
+	member := FindGroupMember(uint(msg.TargetId), uint(msg.UserId))
+	if member.ID == 0 {
+		return false
+	}
+	if member.Role >= RoleAdmin {
+		return true
+	}
+	if msg.ThreadId == 0 && findCommunity(uint(msg.TargetId)).Type == GroupChannel {
+		return false
+	}
+	return member.MuteUntil <= int64(msg.CreateTime)
+}
+
+// MuteMember 禁言群成员  seconds 为0时解除禁言
+func MuteMember(userId uint, groupId uint, targetId uint, seconds int) (int, string) {
+	if seconds < 0 || seconds > 30*24*3600 {
+		return -1, "禁言时长不合法"
+	}
+	target := FindGroupMember(groupId, targetId)
+	if target.ID == 0 {
+		return -1, "对方不是群成员"
+	}
+	if userId == targetId || !canModerate(userId, groupId, targetId) {
+		return -1, "没有权限"
+	}
+	until := int64(0)
+	if seconds > 0 {
+		until = time.Now().Unix() + int64(seconds)
+	}
+	if err := utils.DB.Model(&target).Update("mute_until", until).Error; err != nil {
+		return -1, "设置失败"
+	}
+	name := FindByID(targetId).Name
+	if seconds > 0 {
+		audit(groupId, userId, targetId, "mute", formatTTL(seconds))
+		groupNotice(groupId, userId, name+"被禁言"+formatTTL(seconds))
+	} else {
+		audit(groupId, userId, targetId, "unmute", "")
+		groupNotice(groupId, userId, name+"被解除禁言")
+	}
+	pushEvent(int64(targetId), "group_mute", map[string]interface{}{"GroupId": groupId, "MuteUntil": until})
+	return 0, "设置成功"
+}
+
+// SetSlowMode 设置慢速模式  每个成员每 seconds 秒只能发一条 0关闭
+func SetSlowMode(userId uint, groupId uint, seconds int) (int, string) {
+	if seconds < 0 || seconds > 3600 {
+		return -1, "时长不合法"
+	}
+	community := findCommunity(groupId)
+	if community.ID == 0 {
+		return -1, "没有找到群"
+	}
+	if !IsGroupAdmin(userId, groupId) {
+		return -1, "只有群管理员可以设置"
+	}
+	if err := utils.DB.Model(&community).Update("slow_mode", seconds).Error; err != nil {
+		return -1, "设置失败"
+	}
+	content := "关闭了慢速模式"
+	if seconds > 0 {
+		content = "开启了慢速模式：每" + formatTTL(seconds) + "只能发言一次"
+	}
+	audit(groupId, userId, 0, "slow_mode", strconv.Itoa(seconds))
+	groupNotice(groupId, userId, FindByID(userId).Name+content)
+	groupEvent(groupId, "group_update", findCommunity(groupId))
+	return 0, "设置成功"
+}
+
+// LoadAuditLogs 群管理操作记录
+func LoadAuditLogs(userId uint, groupId uint, page int, size int) ([]GroupAuditLog, int64, int, string) {
+	logs := make([]GroupAuditLog, 0)
+	var total int64
+	if !IsGroupAdmin(userId, groupId) {
+		return logs, 0, -1, "没有权限"
+	}
+	db := utils.DB.Model(&GroupAuditLog{}).Where("group_id = ?", groupId)
+	db.Count(&total)
+	db.Order("id desc").Offset((page - 1) * size).Limit(size).Find(&logs)
+	return logs, total, 0, "ok"
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"GinChat/utils"
+
+	"gorm.io/gorm"
+)
+
+// PinnedMessage 会话置顶消息  会话内所有人可见
+type PinnedMessage struct {
+	gorm.Model
+	ConvKey   string //所属会话  私聊 msg_小ID_大ID  群聊 group_群ID
+	MessageId uint   //置顶的消息
+	PinnedBy  uint   //操作人
+}
+
+func (table *PinnedMessage) TableName() string {
+	return "pinned_message"
+}
+
+// StarredMessage 用户收藏的消息
+type StarredMessage struct {
+	gorm.Model
+	UserId    uint   //收藏人
+	MessageId uint   //收藏的消息
+	ConvKey   string //消息所属会话
+}
+
+func (table *StarredMessage) TableName() string {
+	return "starred_message"
+}
+
+// SavedItem 置顶或收藏列表项 附带消息内容
+type SavedItem struct {
+	ID        uint //置顶/收藏记录ID
+	ConvKey   string
+	MessageId uint
+	Operator  uint //置顶人或收藏人
+	Message   Message
+}
+
+// chatKey 消息所在的私聊或群聊  话题消息归属其所在的群
+func chatKey(msg Message) string {
+	if msg.Type == 2 {
+		return groupKey(uint(msg.TargetId))
+	}
+	return msgKey(msg.UserId, msg.TargetId)
+}
+
+// findLiveMsg 查找未被删除且用户可见的消息
+func findLiveMsg(userId uint, msgId uint) (Message, int, string) {
+	msg := Message{}
+	utils.DB.Where("id = ?", msgId).Find(&msg)
+	if msg.ID == 0 {
+		return msg, -1, "消息不存在或已删除"
+	}
+	if !IsMsgVisible(userId, msg) {
+		return msg, -1, "无权操作此消息"
+	}
+	return msg, 0, ""
+}
+
+// PinMsg 置顶消息  群聊中只有管理者可以置顶
+func PinMsg(userId uint, msgId uint) (int, string) {
+	msg, code, info := findLiveMsg(userId, msgId)
+	if code != 0 {
+		return code, info
+	}
+	if msg.Type == 2 && !IsGroupAdmin(userId, uint(msg.TargetId)) {
+		return -1, "只有群管理员可以置顶消息"
+	}
+	pinned := PinnedMessage{}
+	key := chatKey(msg)
+	utils.DB.Where("conv_key = ? and message_id = ?", key, msgId).Find(&pinned)
+	if pinned.ID != 0 {
+		return -1, "消息已置顶"
+	}
+	pinned = PinnedMessage{ConvKey: key, MessageId: msgId, PinnedBy: userId}
+	if err := utils.DB.Create(&pinned).Error; err != nil {
+		return -1, "置顶失败"
+	}
+	data := msgRef(msg)
+	data["PinnedBy"] = userId
+	msgEvent(msg, "msg_pin", data)
+	return 0, "置顶成功"
+}
+
+// UnpinMsg 取消置顶
+func UnpinMsg(userId uint, msgId uint) (int, string) {
+	msg, err := FindMsgByID(msgId)
+	if err != nil {
+		return -1, "消息不存在"
+	}
+	if !IsMsgVisible(userId, msg) {
+		return -1, "无权操作此消息"
+	}
+	if msg.Type == 2 && !IsGroupAdmin(userId, uint(msg.TargetId)) {
+		return -1, "只有群管理员可以取消置顶"
+	}
+	res := utils.DB.Where("conv_key = ? and message_id = ?", chatKey(msg), msgId).Delete(&PinnedMessage{})
+	if res.RowsAffected == 0 {
+		return -1, "消息未置顶"
+	}
+	msgEvent(msg, "msg_unpin", msgRef(msg))
+	return 0, "取消置顶成功"
+}
+
+// LoadPinned 会话的置顶消息
+// @Param targetType & targetId	1私聊对方ID  2群ID
+func LoadPinned(userId uint, targetType int, targetId int64) ([]SavedItem, int, string) {
+	key := msgKey(int64(userId), targetId)
+	if targetType == 2 {
+		if !IsGroupMember(userId, uint(targetId)) {
+			return nil, -1, "不是群成员"
+		}
+		key = groupKey(uint(targetId))
+	}
+	pins := make([]PinnedMessage, 0)
+	utils.DB.Where("conv_key = ?", key).Order("id desc").Find(&pins)
+	items := make([]SavedItem, 0, len(pins))
+	for _, v := range pins {
+		items = append(items, SavedItem{ID: v.ID, ConvKey: v.ConvKey, MessageId: v.MessageId, Operator: v.PinnedBy})
+	}
+	return fillSavedItems(items), 0, "ok"
+}
+
+// StarMsg 收藏消息
+func StarMsg(userId uint, msgId uint) (int, string) {
+	msg, code, info := findLiveMsg(userId, msgId)
+	if code != 0 {
+		return code, info
+	}
+	starred := StarredMessage{}
+	utils.DB.Where("user_id = ? and message_id = ?", userId, msgId).Find(&starred)
+	if starred.ID != 0 {
+		return -1, "已收藏"
+	}
+	starred = StarredMessage{UserId: userId, MessageId: msgId, ConvKey: chatKey(msg)}
+	if err := utils.DB.Create(&starred).Error; err != nil {
+		return -1, "收藏失败"
+	}
+	return 0, "收藏成功"
+}
+
+// UnstarMsg 取消收藏
+func UnstarMsg(userId uint, msgId uint) (int, string) {
+	res := utils.DB.Where("user_id = ? and message_id = ?", userId, msgId).Delete(&StarredMessage{})
+	if res.RowsAffected == 0 {
+		return -1, "未收藏此消息"
+	}
+	return 0, "取消收藏成功"
+}
+
+// LoadStarred 用户跨会话的收藏列表 按收藏时间倒序分页
+func LoadStarred(userId uint, page int, size int) ([]SavedItem, int64) {
+	var total int64
+	stars := make([]StarredMessage, 0)
+	db := utils.DB.Model(&StarredMessage{}).Where("user_id = ?", userId)
+	db.Count(&total)
+	db.Order("id desc").Offset((page - 1) * size).Limit(size).Find(&stars)
+	items := make([]SavedItem, 0, len(stars))
+	for _, v := range stars {
+		items = append(items, SavedItem{ID: v.ID, ConvKey: v.ConvKey, MessageId: v.MessageId, Operator: v.UserId})
+	}
+	return fillSavedItems(items), total
+}
+
+// fillSavedItems 从MySQL补齐消息内容 已删除的消息只保留占位
+func fillSavedItems(items []SavedItem) []SavedItem {
+	ids := make([]uint, 0, len(items))
+	for _, v := range items {
+		ids = append(ids, v.MessageId)
+	}
+	msgs := make([]Message, 0)
+	utils.DB.Unscoped().Where("id in ?", ids).Find(&msgs)
+	msgMap := make(map[uint]Message)
+	for _, v := range msgs {
+		if v.DeletedAt.Valid {
+			v = msgTombstone(v)
+		}
+		msgMap[v.ID] = v
+	}
+	for i := range items {
+		items[i].Message = msgMap[items[i].MessageId]
+	}
+	return items
+}
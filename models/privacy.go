@@ -0,0 +1,184 @@
+package models
+
+import (
+	"GinChat/utils"
+
+	"gorm.io/gorm"
+)
+
+// 隐私范围
+const (
+	PrivacyEveryone = 0 //所有人
+	PrivacyFriends  = 1 //仅好友
+	PrivacyNobody   = 2 //任何人都不可以
+)
+
+// UserBlock 黑名单  被拉黑的人不能给拉黑者发私信 好友申请和群邀请
+type UserBlock struct {
+	gorm.Model
+	UserId    uint //谁拉黑的
+	BlockedId uint //被拉黑的人
+}
+
+func (table *UserBlock) TableName() string {
+	return "user_block"
+}
+
+// PrivacySetting 用户隐私设置  没有记录时全部为所有人可见
+type PrivacySetting struct {
+	gorm.Model
+	UserId       uint `gorm:"uniqueIndex"`
+	FindByName   int  //谁可以通过用户名找到我
+	FindByPhone  int  //谁可以通过手机号找到我
+	FindByEmail  int  //谁可以通过邮箱找到我
+	AddMe        int  //谁可以加我为好友  只区分所有人和任何人都不可以
+	OnlineStatus int  //谁可以看到我的在线状态
+}
+
+func (table *PrivacySetting) TableName() string {
+	return "privacy_setting"
+}
+
+// IsBlocked userId 是否拉黑了 targetId
+func IsBlocked(userId uint, targetId uint) bool {
+	block := UserBlock{}
+	utils.DB.Where("user_id = ? and blocked_id = ?", userId, targetId).Find(&block)
+	return block.ID != 0
+}
+
+// BlockUser 拉黑/取消拉黑  拉黑时顺带拒绝对方待处理的好友申请
+func BlockUser(userId uint, targetId uint, block bool) (int, string) {
+	if userId == targetId {
+		return -1, "不能拉黑自己"
+	}
+	if !block {
+		utils.DB.Unscoped().Where("user_id = ? and blocked_id = ?", userId, targetId).Delete(&UserBlock{})
+		return 0, "已移出黑名单"
+	}
+	if FindByID(targetId).ID == 0 {
+		return -1, "没有找到此用户"
+	}
+	if IsBlocked(userId, targetId) {
+		return 0, "已拉黑"
+	}
+	if err := utils.DB.Create(&UserBlock{UserId: userId, BlockedId: targetId}).Error; err != nil {
+		return -1, "拉黑失败"
+	}
+	utils.DB.Model(&FriendRequest{}).Where("from_id = ? and to_id = ? and status = ?", targetId, userId, RequestPending).
+		Update("status", RequestRejected)
+	return 0, "已拉黑"
+}
+
+// LoadBlocked 黑名单列表
+func LoadBlocked(userId uint) []UserBasic {
+	ids := make([]uint, 0)
+	utils.DB.Model(&UserBlock{}).Where("user_id = ?", userId).Pluck("blocked_id", &ids)
+	users := make([]UserBasic, 0)
+	if len(ids) == 0 {
+		return users
+	}
+	utils.DB.Where("id in ?", ids).Select("id", "name", "avatar").Find(&users)
+	return users
+}
+
+// FindPrivacy 查询隐私设置
+func FindPrivacy(userId uint) PrivacySetting {
+	setting := PrivacySetting{}
+	utils.DB.Where("user_id = ?", userId).Find(&setting)
+	setting.UserId = userId
+	return setting
+}
+
+// SetPrivacy 修改隐私设置
+func SetPrivacy(setting PrivacySetting) (int, string) {
+	for _, v := range []int{setting.FindByName, setting.FindByPhone, setting.FindByEmail, setting.OnlineStatus} {
+		if v < PrivacyEveryone || v > PrivacyNobody {
+			return -1, "隐私范围不正确"
+		}
+	}
+	if setting.AddMe != PrivacyEveryone && setting.AddMe != PrivacyNobody {
+		return -1, "隐私范围不正确"
+	}
+	old := FindPrivacy(setting.UserId)
+	setting.ID = old.ID
+	setting.CreatedAt = old.CreatedAt
+	if err := utils.DB.Save(&setting).Error; err != nil {
+		return -1, "修改失败"
+	}
+	return 0, "修改成功"
+}
+
+// privacyAllows viewerId 是否在 scope 允许的范围内  被拉黑的人什么都看不到
+func privacyAllows(scope int, viewerId uint, ownerId uint) bool {
+	return privacyAllowsBatch(map[uint]int{ownerId: scope}, viewerId)[ownerId]
+}
+
+// privacyAllowsBatch 批量判断  scopes 为 ownerId -> 隐私范围  规则与 privacyAllows 相同
+func privacyAllowsBatch(scopes map[uint]int, viewerId uint) map[uint]bool {
+	res := make(map[uint]bool, len(scopes))
+	check := make([]uint, 0, len(scopes))
+	for owner, scope := range scopes {
+		if owner == viewerId {
+			res[owner] = true
+			continue
+		}
+		if scope == PrivacyEveryone || scope == PrivacyFriends {
+			check = append(check, owner)
+		}
+	}
+	if len(check) == 0 {
+		return res
+	}
+	blockers := make([]uint, 0)
+	utils.DB.Model(&UserBlock{}).Where("user_id in ? and blocked_id = ?", check, viewerId).Pluck("user_id", &blockers)
+	blocked := make(map[uint]bool, len(blockers))
+	for _, v := range blockers {
+		blocked[v] = true
+	}
+	// 对方的好友列表里有自己
+	friendOf := make([]uint, 0)
+	utils.DB.Model(&Contact{}).Where("owner_id in ? and target_id = ? and type = 1", check, viewerId).Pluck("owner_id", &friendOf)
+	friends := make(map[uint]bool, len(friendOf))
+	for _, v := range friendOf {
+		friends[v] = true
+	}
+	for _, owner := range check {
+		if blocked[owner] {
+			continue
+		}
+		res[owner] = scopes[owner] == PrivacyEveryone || friends[owner]
+	}
+	return res
+}
+
+// CanFindBy viewerId 能否通过 field(name/phone/email) 搜到 ownerId
+func CanFindBy(field string, viewerId uint, ownerId uint) bool {
+	setting := FindPrivacy(ownerId)
+	scope := setting.FindByName
+	switch field {
+	case "phone":
+		scope = setting.FindByPhone
+	case "email":
+		scope = setting.FindByEmail
+	}
+	return privacyAllows(scope, viewerId, ownerId)
+}
+
+// CanSeeOnlineBatch 批量判断 viewerId 能否看到这些用户的在线状态
+func CanSeeOnlineBatch(viewerId uint, ownerIds []uint) map[uint]bool {
+	scopes := make(map[uint]int, len(ownerIds))
+	for _, v := range ownerIds {
+		scopes[v] = PrivacyEveryone
+	}
+	settings := make([]PrivacySetting, 0)
+	utils.DB.Where("user_id in ?", ownerIds).Find(&settings)
+	for _, v := range settings {
+		scopes[v.UserId] = v.OnlineStatus
+	}
+	return privacyAllowsBatch(scopes, viewerId)
+}
+
+// canAddFriend userId 能否向 targetId 发好友申请
+func canAddFriend(userId uint, targetId uint) bool {
+	return privacyAllows(FindPrivacy(targetId).AddMe, userId, targetId)
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Reaction 某个表情的回应情况
+type Reaction struct {
+	Count   int64  //回应人数
+	UserIds []uint //回应的用户
+}
+
+// reactionKey 消息的回应计数  emoji -> count
+func reactionKey(msgId uint) string {
+	return "reaction_" + strconv.Itoa(int(msgId))
+}
+
+// reactionUserKey 对消息回应某个表情的用户集合
+func reactionUserKey(msgId uint, emoji string) string {
+	return reactionKey(msgId) + "_" + emoji
+}
+
+// ToggleReaction 对消息回应表情  已回应过则取消
+func ToggleReaction(userId uint, msgId uint, emoji string) (map[string]interface{}, int, string) {
+	if emoji == "" || len([]rune(emoji)) > 8 {
+		return nil, -1, "表情不合法"
+	}
+	msg, err := FindMsgByID(msgId)
+	if err != nil || msg.DeletedAt.Valid {
+		return nil, -1, "消息不存在"
+	}
+	if !IsMsgVisible(userId, msg) {
+		return nil, -1, "无权回应此消息"
+	}
+	ctx := context.Background()
+	userKey := reactionUserKey(msgId, emoji)
+	added, err := utils.RedisCluster.SAdd(ctx, userKey, userId).Result()
+	if err != nil {
+		return nil, -1, "回应失败"
+	}
+	var count int64
+	if added == 1 {
+		count, err = utils.RedisCluster.HIncrBy(ctx, reactionKey(msgId), emoji, 1).Result()
+	} else {
+		utils.RedisCluster.SRem(ctx, userKey, userId)
+		count, err = utils.RedisCluster.HIncrBy(ctx, reactionKey(msgId), emoji, -1).Result()
+		if count <= 0 {
+			utils.RedisCluster.HDel(ctx, reactionKey(msgId), emoji)
+			count = 0
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	data := msgRef(msg)
+	data["Emoji"] = emoji
+	data["ReactUserId"] = userId
+	data["Add"] = added == 1
+	data["Count"] = count
+	msgEvent(msg, "msg_reaction", data)
+	return data, 0, "ok"
+}
+
+// LoadReactions 批量获取消息的表情回应
+func LoadReactions(msgIds []uint) map[uint]map[string]*Reaction {
+	res := make(map[uint]map[string]*Reaction)
+	if len(msgIds) == 0 {
+		return res
+	}
+	ctx := context.Background()
+	pipe := utils.RedisCluster.Pipeline()
+	counts := make([]*redis.MapStringStringCmd, len(msgIds))
+	for i, id := range msgIds {
+		counts[i] = pipe.HGetAll(ctx, reactionKey(id))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		fmt.Println(err)
+	}
+	for i, id := range msgIds {
+		for emoji, v := range counts[i].Val() {
+			count, _ := strconv.ParseInt(v, 10, 64)
+			if count <= 0 {
+				continue
+			}
+			reaction := &Reaction{Count: count, UserIds: make([]uint, 0)}
+			users, _ := utils.RedisCluster.SMembers(ctx, reactionUserKey(id, emoji)).Result()
+			for _, u := range users {
+				uid, _ := strconv.Atoi(u)
+				reaction.UserIds = append(reaction.UserIds, uint(uid))
+			}
+			if res[id] == nil {
+				res[id] = make(map[string]*Reaction)
+			}
+			res[id][emoji] = reaction
+		}
+	}
+	return res
+}
+
+// clearReactions 删除消息的全部回应
+func clearReactions(msgId uint) {
+	ctx := context.Background()
+	emojis, _ := utils.RedisCluster.HKeys(ctx, reactionKey(msgId)).Result()
+	for _, emoji := range emojis {
+		utils.RedisCluster.Del(ctx, reactionUserKey(msgId, emoji))
+	}
+	utils.RedisCluster.Del(ctx, reactionKey(msgId))
+}
+
+// attachReactions 给历史消息附上表情回应
+func attachReactions(rels []string) []string {
+	msgs := make([]Message, len(rels))
+	ids := make([]uint, 0)
+	for i, v := range rels {
+		if json.Unmarshal([]byte(v), &msgs[i]) == nil && msgs[i].ID != 0 {
+			ids = append(ids, msgs[i].ID)
+		}
+	}
+	reactions := LoadReactions(ids)
+	if len(reactions) == 0 {
+		return rels
+	}
+	for i := range rels {
+		if r, ok := reactions[msgs[i].ID]; ok {
+			msgs[i].Reactions = r
+			bytes, err := json.Marshal(msgs[i])
+			if err == nil {
+				rels[i] = string(bytes)
+			}
+		}
+	}
+	return rels
+}
@@ -0,0 +1,427 @@
+package models
+
+import (
+	"GinChat/utils"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+const (
+	retentionLockKey   = "retention_lock"   //多节点只允许一个节点整理
+	retentionReportKey = "retention_report" //最近一次整理报告
+	trimPageSize       = 500                //每次从Redis读取的消息条数
+)
+
+// RetentionPolicy 热数据保留策略  条数和天数任一超出即移出Redis
+type RetentionPolicy struct {
+	HotCount int64 //Redis中保留的最新消息条数 0不限
+	HotDays  int   //Redis中保留的天数 0不限
+}
+
+// CompactReport 一次整理的结果
+type CompactReport struct {
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	KeysScanned  int      //扫描的会话数
+	TrimmedRedis int      //移出Redis的消息数
+	SavedMySQL   int      //移出前补写入MySQL的旧消息数
+	Archived     int      //内容归档到文件 MySQL中只留占位记录的消息数
+	ArchiveFiles []string //写入的归档文件
+	Errors       []string
+}
+
+// defaultRetention 全局保留策略
+func defaultRetention() RetentionPolicy {
+	return RetentionPolicy{
+		HotCount: viper.GetInt64("retention.HotCount"),
+		HotDays:  viper.GetInt("retention.HotDays"),
+	}
+}
+
+// retentionFor 会话的保留策略  群可单独设置 未设置的项沿用全局配置
+func retentionFor(key string) RetentionPolicy {
+	policy := defaultRetention()
+	if !strings.HasPrefix(key, "group_") {
+		return policy
+	}
+	setting := FindConvSetting(key)
+	if setting.RetentionCount > 0 {
+		policy.HotCount = setting.RetentionCount
+	}
+	if setting.RetentionDays > 0 {
+		policy.HotDays = setting.RetentionDays
+	}
+	return policy
+}
+
+// SetGroupRetention 群管理员设置本群的热数据保留策略  0表示沿用全局配置
+func SetGroupRetention(userId uint, groupId uint, count int64, days int) (int, string) {
+	if count < 0 || days < 0 {
+		return -1, "参数不合法"
+	}
+	if !IsGroupAdmin(userId, groupId) {
+		return -1, "只有群管理员可以设置"
+	}
+	key := groupKey(groupId)
+	setting := FindConvSetting(key)
+	setting.ConvKey = key
+	setting.RetentionCount = count
+	setting.RetentionDays = days
+	setting.UpdatedBy = userId
+	if err := utils.DB.Save(&setting).Error; err != nil {
+		return -1, "设置失败"
+	}
+	return 0, "设置成功"
+}
+
+// CompactMessages 定时整理消息  Redis -> MySQL -> 归档文件
+func CompactMessages() bool {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("compact messages err", r)
+		}
+	}()
+	ctx := context.Background()
+	ok, err := utils.RedisCluster.SetNX(ctx, retentionLockKey, 1, time.Hour).Result()
+	if err != nil || !ok {
+		return true
+	}
+	defer utils.RedisCluster.Del(ctx, retentionLockKey)
+
+	report := CompactReport{StartedAt: time.Now()}
+	keys := scanMsgKeys(ctx, &report)
+	for _, key := range keys {
+		trimHotMsgs(ctx, key, retentionFor(key), &report)
+	}
+	report.KeysScanned = len(keys)
+	if days := viper.GetInt("retention.ArchiveDays"); days > 0 {
+		archiveColdMsgs(days, &report)
+	}
+	report.FinishedAt = time.Now()
+	data, _ := json.Marshal(report)
+	utils.RedisCluster.Set(ctx, retentionReportKey, data, 0)
+	fmt.Println("compact messages report: ", string(data))
+	return true
+}
+
+// LastCompactReport 最近一次整理报告
+func LastCompactReport() CompactReport {
+	report := CompactReport{}
+	data, err := utils.RedisCluster.Get(context.Background(), retentionReportKey).Result()
+	if err == nil {
+		json.Unmarshal([]byte(data), &report)
+	}
+	return report
+}
+
+// scanMsgKeys 在所有主节点上扫描会话缓存key
+func scanMsgKeys(ctx context.Context, report *CompactReport) []string {
+	var mu sync.Mutex
+	keys := make([]string, 0)
+	err := utils.RedisCluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		for _, pattern := range []string{"msg_*", "group_*"} {
+			iter := client.Scan(ctx, 0, pattern, 500).Iterator()
+			for iter.Next(ctx) {
+				mu.Lock()
+				keys = append(keys, iter.Val())
+				mu.Unlock()
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	return keys
+}
+
+// trimHotMsgs 把超出保留策略的旧消息移出Redis  没有ID的早期消息先补写入MySQL
+func trimHotMsgs(ctx context.Context, key string, policy RetentionPolicy, report *CompactReport) {
+	if kind, _ := utils.RedisCluster.Type(ctx, key).Result(); kind != "zset" {
+		return
+	}
+	card, err := utils.RedisCluster.ZCard(ctx, key).Result()
+	if err != nil || card == 0 {
+		return
+	}
+	var overCount int64
+	if policy.HotCount > 0 && card > policy.HotCount {
+		overCount = card - policy.HotCount
+	}
+	var cutoff uint64
+	if policy.HotDays > 0 {
+		cutoff = uint64(time.Now().AddDate(0, 0, -policy.HotDays).Unix())
+	}
+	if overCount == 0 && cutoff == 0 {
+		return
+	}
+	// 从最旧的开始分页检查 数量超出的一定移出 其余按时间判断  移除后下一页仍从头部开始 补写失败的跳过
+	var checked, skipped int64
+	for {
+		size := int64(trimPageSize)
+		if cutoff == 0 && overCount-checked < size {
+			size = overCount - checked
+		}
+		if size <= 0 {
+			return
+		}
+		members, err := utils.RedisCluster.ZRange(ctx, key, skipped, skipped+size-1).Result()
+		if err != nil {
+			report.Errors = append(report.Errors, key+": "+err.Error())
+			return
+		}
+		if len(members) == 0 {
+			return
+		}
+		for _, member := range members {
+			msg := Message{}
+			json.Unmarshal([]byte(member), &msg)
+			if checked >= overCount && (cutoff == 0 || msg.CreateTime >= cutoff) {
+				return
+			}
+			checked++
+			if msg.ID == 0 && (msg.Type == 1 || msg.Type == 2) {
+				if err := utils.DB.Create(&msg).Error; err != nil {
+					report.Errors = append(report.Errors, key+": "+err.Error())
+					skipped++
+					continue
+				}
+				report.SavedMySQL++
+			}
+			utils.RedisCluster.ZRem(ctx, key, member)
+			report.TrimmedRedis++
+		}
+	}
+}
+
+// archiveColdMsgs 把MySQL中超过天数的消息按会话和月份写入gzip归档文件
+// MySQL中保留ID 序号 收发双方等占位信息 内容清空并记下归档文件 读取时由 AfterFind 从归档还原
+// 占位记录的 content 为空 MySQL FULLTEXT 搜索不到已归档的消息
+func archiveColdMsgs(days int, report *CompactReport) {
+	cutoff := uint64(time.Now().AddDate(0, 0, -days).Unix())
+	dir := viper.GetString("retention.ArchiveDir")
+	if dir == "" {
+		dir = "./archive/"
+	}
+	for {
+		msgs := make([]Message, 0)
+		utils.DB.Unscoped().Where("create_time < ? and (archive = '' or archive is null)", cutoff).Order("id").Limit(1000).Find(&msgs)
+		if len(msgs) == 0 {
+			return
+		}
+		files := make(map[string][]Message)
+		for _, v := range msgs {
+			month := time.Unix(int64(v.CreateTime), 0).Format("200601")
+			name := filepath.Join(dir, convKey(v), month+".jsonl.gz")
+			files[name] = append(files[name], v)
+		}
+		archived := 0
+		for name, list := range files {
+			if err := appendArchive(name, list); err != nil {
+				report.Errors = append(report.Errors, name+": "+err.Error())
+				continue
+			}
+			report.ArchiveFiles = appendUnique(report.ArchiveFiles, name)
+			ids := make([]uint, 0, len(list))
+			for _, v := range list {
+				ids = append(ids, v.ID)
+			}
+			utils.DB.Unscoped().Model(&Message{}).Where("id in ?", ids).Updates(map[string]interface{}{
+				"content": "", "pic": "", "url": "", "desc": "", "quote": "", "archive": name,
+			})
+			archived += len(ids)
+		}
+		if archived == 0 {
+			return
+		}
+		report.Archived += archived
+	}
+}
+
+// archiveCache 最近读取过的归档文件  文件名 -> 消息ID -> 消息
+var archiveCache = struct {
+	sync.Mutex
+	files map[string]map[uint]Message
+}{files: make(map[string]map[uint]Message)}
+
+// loadArchive 读取归档文件中的全部消息
+func loadArchive(name string) (map[uint]Message, error) {
+	archiveCache.Lock()
+	defer archiveCache.Unlock()
+	if msgs, ok := archiveCache.files[name]; ok {
+		return msgs, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	msgs := make(map[uint]Message)
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		msg := Message{}
+		if json.Unmarshal(scanner.Bytes(), &msg) == nil {
+			msgs[msg.ID] = msg
+		}
+	}
+	if len(archiveCache.files) >= 16 {
+		archiveCache.files = make(map[string]map[uint]Message)
+	}
+	archiveCache.files[name] = msgs
+	return msgs, scanner.Err()
+}
+
+// AfterFind 已归档的消息从归档文件还原内容  历史 搜索结果 置顶 收藏和导出都经过这里
+func (table *Message) AfterFind(tx *gorm.DB) error {
+	if table.Archive == "" {
+		return nil
+	}
+	msgs, err := loadArchive(table.Archive)
+	if err != nil {
+		fmt.Println("load archive fail: ", table.Archive, err)
+		return nil
+	}
+	if origin, ok := msgs[table.ID]; ok {
+		table.Content = origin.Content
+		table.Pic = origin.Pic
+		table.Url = origin.Url
+		table.Desc = origin.Desc
+		table.Quote = origin.Quote
+	}
+	return nil
+}
+
+// appendArchive 追加写入归档文件  每次追加一个独立的gzip段 可直接用 zcat 连续读取
+func appendArchive(name string, msgs []Message) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	for _, v := range msgs {
+		line, _ := json.Marshal(v)
+		zw.Write(append(line, '\n'))
+	}
+	return zw.Close()
+}
+
+// dropArchiveUser 重写归档文件 去掉某个用户发送的消息  先写临时文件再替换
+func dropArchiveUser(name string, userId uint) error {
+	archiveCache.Lock()
+	defer archiveCache.Unlock()
+	delete(archiveCache.files, name)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tmp := name + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	zw := gzip.NewWriter(out)
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		msg := Message{}
+		if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.UserId == int64(userId) {
+			continue
+		}
+		zw.Write(append(scanner.Bytes(), '\n'))
+	}
+	if err = scanner.Err(); err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// mysqlPrivateMsgs 从MySQL读取已移出Redis的更早私聊消息  按序号倒序
+// @Param beforeSeq	只取序号小于此值的消息
+func mysqlPrivateMsgs(userIdA int64, userIdB int64, beforeSeq int64, offset int64, limit int64) []string {
+	msgs := make([]Message, 0)
+	utils.DB.Unscoped().
+		Where("type = 1 and thread_id = 0 and ((user_id = ? and target_id = ?) or (user_id = ? and target_id = ?))",
+			userIdA, userIdB, userIdB, userIdA).
+		Where("seq < ?", beforeSeq).
+		Order("seq desc").Offset(int(offset)).Limit(int(limit)).Find(&msgs)
+	rels := make([]string, 0, len(msgs))
+	for _, v := range msgs {
+		if v.DeletedAt.Valid {
+			v = msgTombstone(v)
+		}
+		data, _ := json.Marshal(v)
+		rels = append(rels, string(data))
+	}
+	return rels
+}
+
+// olderFromMySQL 缓存中的消息不够一页时 从MySQL补齐更早的消息
+func olderFromMySQL(userIdA int64, userIdB int64, start int64, end int64, rels []string) []string {
+	want := end - start + 1
+	if end < 0 || int64(len(rels)) >= want {
+		return rels
+	}
+	ctx := context.Background()
+	key := msgKey(userIdA, userIdB)
+	card, _ := utils.RedisCluster.ZCard(ctx, key).Result()
+	beforeSeq := int64(math.MaxInt64)
+	if oldest, err := utils.RedisCluster.ZRangeWithScores(ctx, key, 0, 0).Result(); err == nil && len(oldest) > 0 {
+		beforeSeq = int64(oldest[0].Score)
+	}
+	offset := start - card
+	if offset < 0 {
+		offset = 0
+	}
+	return append(rels, mysqlPrivateMsgs(userIdA, userIdB, beforeSeq, offset, want-int64(len(rels)))...)
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+const (
+	scheduleQueueKey      = "{schedule}_queue"      //待发送队列 分数为发送时间
+	scheduleProcessingKey = "{schedule}_processing" //发送中的任务 分数为租约到期时间
+	scheduleJobKey        = "schedule_job"          //任务内容 jobId -> json
+	scheduleIdKey         = "schedule_job_id"       //任务ID自增
+)
+
+// errScheduleSent 任务的消息已经落库 租约过期被重新领取时不再发送
+var errScheduleSent = errors.New("scheduled msg already sent")
+
+// scheduleSentKey 任务已发送标记
+func scheduleSentKey(jobId int64) string {
+	return "schedule_sent_" + strconv.FormatInt(jobId, 10)
+}
+
+// markScheduleSent 消息落库前占用发送标记 同一任务只有一次能成功
+func markScheduleSent(jobId int64) error {
+	ok, err := utils.RedisCluster.SetNX(context.Background(), scheduleSentKey(jobId), 1, 24*time.Hour).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errScheduleSent
+	}
+	return nil
+}
+
+// unmarkScheduleSent 落库失败时释放发送标记 让任务可以重试
+func unmarkScheduleSent(jobId int64) {
+	utils.RedisCluster.Del(context.Background(), scheduleSentKey(jobId))
+}
+
+// ScheduledMsg 定时消息任务
+type ScheduledMsg struct {
+	ID       int64
+	UserId   uint    //发送者
+	FireAt   int64   //发送时间 unix秒
+	CreateAt int64   //创建时间
+	Msg      Message //到点后要发送的消息
+	Attempts int     //已失败的次数
+}
+
+// scheduleUserKey 用户的定时任务ID集合
+func scheduleUserKey(userId uint) string {
+	return "schedule_user_" + strconv.Itoa(int(userId))
+}
+
+// CreateScheduledMsg 创建定时消息  任务存在Redis中 重启不会丢失
+func CreateScheduledMsg(userId uint, msg Message, fireAt int64) (ScheduledMsg, int, string) {
+	job := ScheduledMsg{}
+	if msg.Type != 1 && msg.Type != 2 {
+		return job, -1, "消息类型不合法"
+	}
+	// 合并转发和系统消息只能由服务端产生
+	if msg.Media == 5 || msg.Media == 6 {
+		return job, -1, "消息类型不合法"
+	}
+	if msg.Type == 2 && !IsGroupMember(userId, uint(msg.TargetId)) {
+		return job, -1, "不是群成员"
+	}
+	now := time.Now().Unix()
+	if fireAt <= now {
+		return job, -1, "发送时间必须晚于当前时间"
+	}
+	ctx := context.Background()
+	id, err := utils.RedisCluster.Incr(ctx, scheduleIdKey).Result()
+	if err != nil {
+		return job, -1, "创建定时消息失败"
+	}
+	msg.UserId = int64(userId)
+	job = ScheduledMsg{ID: id, UserId: userId, FireAt: fireAt, CreateAt: now, Msg: msg}
+	data, _ := json.Marshal(job)
+	idStr := strconv.FormatInt(id, 10)
+	if err = utils.RedisCluster.HSet(ctx, scheduleJobKey, idStr, data).Err(); err != nil {
+		return job, -1, "创建定时消息失败"
+	}
+	utils.RedisCluster.SAdd(ctx, scheduleUserKey(userId), idStr)
+	utils.RedisCluster.ZAdd(ctx, scheduleQueueKey, redis.Z{Score: float64(fireAt), Member: idStr})
+	return job, 0, "创建定时消息成功"
+}
+
+// LoadScheduledMsgs 用户尚未发送的定时消息
+func LoadScheduledMsgs(userId uint) []ScheduledMsg {
+	ctx := context.Background()
+	res := make([]ScheduledMsg, 0)
+	ids, err := utils.RedisCluster.SMembers(ctx, scheduleUserKey(userId)).Result()
+	if err != nil || len(ids) == 0 {
+		return res
+	}
+	vals, _ := utils.RedisCluster.HMGet(ctx, scheduleJobKey, ids...).Result()
+	for _, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		job := ScheduledMsg{}
+		if json.Unmarshal([]byte(str), &job) == nil {
+			res = append(res, job)
+		}
+	}
+	return res
+}
+
+// CancelScheduledMsg 取消定时消息  只有从队列中成功移除才算取消成功
+func CancelScheduledMsg(userId uint, jobId int64) (int, string) {
+	ctx := context.Background()
+	idStr := strconv.FormatInt(jobId, 10)
+	isOwner, _ := utils.RedisCluster.SIsMember(ctx, scheduleUserKey(userId), idStr).Result()
+	if !isOwner {
+		return -1, "定时消息不存在"
+	}
+	removed, err := utils.RedisCluster.ZRem(ctx, scheduleQueueKey, idStr).Result()
+	if err != nil || removed == 0 {
+		return -1, "定时消息已发送或已取消"
+	}
+	utils.RedisCluster.HDel(ctx, scheduleJobKey, idStr)
+	utils.RedisCluster.SRem(ctx, scheduleUserKey(userId), idStr)
+	return 0, "取消成功"
+}
+
+// claimScript 领取到期任务  从待发送队列移到处理中集合 分数为租约到期时间
+// 租约已过期的任务(发送节点崩溃)重新领取  两个key在同一个槽里 脚本保证原子性
+var claimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, id in ipairs(due) do
+	redis.call('ZREM', KEYS[1], id)
+	table.insert(ids, id)
+end
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[2], ARGV[2], id)
+end
+return ids
+`)
+
+// requeueScript 发送失败的任务放回待发送队列
+var requeueScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[2], ARGV[1]) == 1 then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// RunScheduledMsgs 发送到期的定时消息
+// 任务先租给当前节点 发送成功后才删除 节点在租约内崩溃时任务会被重新领取
+// 发送失败的任务稍后重试 超过次数后放弃并通知发送者
+func RunScheduledMsgs() bool {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("run scheduled msgs err", r)
+		}
+	}()
+	ctx := context.Background()
+	now := time.Now().Unix()
+	lease := now + int64(viper.GetInt("schedule.LeaseSeconds"))
+	ids, err := claimScript.Run(ctx, utils.RedisCluster, []string{scheduleQueueKey, scheduleProcessingKey},
+		now, lease, 100).StringSlice()
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+	for _, idStr := range ids {
+		str, err := utils.RedisCluster.HGet(ctx, scheduleJobKey, idStr).Result()
+		if err != nil {
+			fmt.Println("scheduled job lost: ", idStr, err)
+			utils.RedisCluster.ZRem(ctx, scheduleProcessingKey, idStr)
+			continue
+		}
+		job := ScheduledMsg{}
+		if err = json.Unmarshal([]byte(str), &job); err != nil {
+			fmt.Println(err)
+			utils.RedisCluster.ZRem(ctx, scheduleProcessingKey, idStr)
+			continue
+		}
+		job.Msg.ScheduleId = job.ID
+		if _, err = SendMsg(job.Msg); err != nil && !errors.Is(err, errScheduleSent) {
+			fmt.Println("scheduled msg send fail: ", idStr, err)
+			job.Attempts++
+			if job.Attempts < viper.GetInt("schedule.MaxAttempts") {
+				data, _ := json.Marshal(job)
+				utils.RedisCluster.HSet(ctx, scheduleJobKey, idStr, data)
+				requeueScript.Run(ctx, utils.RedisCluster, []string{scheduleQueueKey, scheduleProcessingKey},
+					idStr, now+int64(job.Attempts)*60)
+				continue
+			}
+			pushEvent(int64(job.UserId), "schedule_fail", map[string]interface{}{"ID": job.ID, "Reason": err.Error()})
+		}
+		utils.RedisCluster.ZRem(ctx, scheduleProcessingKey, idStr)
+		utils.RedisCluster.HDel(ctx, scheduleJobKey, idStr)
+		utils.RedisCluster.SRem(ctx, scheduleUserKey(job.UserId), idStr)
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// SearchQuery 消息搜索条件  为零值的条件不生效
+type SearchQuery struct {
+	UserId     uint   //搜索人 只能搜到自己能看到的消息
+	Keyword    string //关键词
+	TargetType int    //会话类型 1私聊 2群聊
+	TargetId   int64  //会话对象 私聊对方ID 或 群ID
+	SenderId   int64  //发送者
+	Media      int    //消息类型
+	StartTime  uint64 //开始时间 unix秒
+	EndTime    uint64 //结束时间 unix秒
+	Page       int
+	Size       int
+}
+
+// SearchHit 搜索结果 附带可跳转到历史消息的锚点
+type SearchHit struct {
+	Message Message
+	Anchor  HistoryAnchor
+}
+
+// HistoryAnchor 历史消息锚点  按会话和序号定位到具体位置
+type HistoryAnchor struct {
+	ConvKey    string
+	TargetType int
+	TargetId   int64 //私聊为对方ID 群聊为群ID
+	ThreadId   uint
+	Seq        int64
+}
+
+// MsgIndex 消息全文检索的实现  默认使用 MySQL FULLTEXT(ngram)
+// 需要其他检索引擎（如本地 Bleve 索引）时实现此接口并通过 RegisterMsgIndex 替换
+type MsgIndex interface {
+	Index(msg Message) error                        //新消息入索引
+	Remove(msgId uint) error                        //删除消息的索引
+	Search(q SearchQuery) ([]Message, int64, error) //按条件搜索 q 中的访问范围必须遵守
+}
+
+var msgIndex MsgIndex = mysqlMsgIndex{}
+
+// RegisterMsgIndex 替换消息检索实现
+func RegisterMsgIndex(index MsgIndex) {
+	msgIndex = index
+}
+
+// mysqlMsgIndex 依赖 message.content 上的 FULLTEXT 索引 MySQL 自动维护
+// 已归档到文件的消息在MySQL中只有空内容的占位记录 不在搜索范围内
+type mysqlMsgIndex struct{}
+
+func (mysqlMsgIndex) Index(msg Message) error {
+	return nil
+}
+
+func (mysqlMsgIndex) Remove(msgId uint) error {
+	return nil
+}
+
+func (mysqlMsgIndex) Search(q SearchQuery) ([]Message, int64, error) {
+	var total int64
+	msgs := make([]Message, 0)
+	db := accessibleMsgs(utils.DB.Model(&Message{}), q.UserId).
+		Where("match(content) against(? in boolean mode)", q.Keyword)
+	if q.TargetType == 1 {
+		db = db.Where("type = 1 and ((user_id = ? and target_id = ?) or (user_id = ? and target_id = ?))",
+			q.UserId, q.TargetId, q.TargetId, q.UserId)
+	} else if q.TargetType == 2 {
+		db = db.Where("type = 2 and target_id = ?", q.TargetId)
+	}
+	if q.SenderId != 0 {
+		db = db.Where("user_id = ?", q.SenderId)
+	}
+	if q.Media != 0 {
+		db = db.Where("media = ?", q.Media)
+	}
+	if q.StartTime != 0 {
+		db = db.Where("create_time >= ?", q.StartTime)
+	}
+	if q.EndTime != 0 {
+		db = db.Where("create_time <= ?", q.EndTime)
+	}
+	if err := db.Count(&total).Error; err != nil {
+		return msgs, 0, err
+	}
+	err := db.Order("id desc").Offset((q.Page - 1) * q.Size).Limit(q.Size).Find(&msgs).Error
+	return msgs, total, err
+}
+
+// accessibleMsgs 限定为用户能看到且没有自己隐藏的消息
+func accessibleMsgs(db *gorm.DB, userId uint) *gorm.DB {
+	// 群设置了新成员不可见历史时 只能看到入群之后的消息  与 canSeeGroupMsg 一样按入群时的序号判断
+	memberOf := utils.DB.Table("group_member gm").Select("1").
+		Joins("join group_basic c on c.id = gm.group_id").
+		Where("gm.group_id = message.target_id and gm.user_id = ?", userId).
+		Where("(c.hide_history = ? or gm.role = ? or (message.thread_id = 0 and message.seq > gm.join_seq) or "+
+			"(message.thread_id <> 0 and exists (select 1 from thread t join message r on r.id = t.root_msg_id "+
+			"where t.id = message.thread_id and r.seq > gm.join_seq)))", false, RoleOwner)
+	hiddenIds := utils.DB.Model(&MessageHidden{}).Select("message_id").Where("user_id = ?", userId)
+	return db.Where("(type = 1 and (user_id = ? or target_id = ?)) or (type = 2 and exists (?))", userId, userId, memberOf).
+		Where("id not in (?)", hiddenIds)
+}
+
+// SearchMsg 搜索聊天记录
+func SearchMsg(q SearchQuery) ([]SearchHit, int64, int, string) {
+	if q.Keyword == "" {
+		return nil, 0, -1, "关键词不能为空"
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.Size <= 0 || q.Size > 100 {
+		q.Size = 20
+	}
+	msgs, total, err := msgIndex.Search(q)
+	if err != nil {
+		return nil, 0, -1, "搜索失败"
+	}
+	hits := make([]SearchHit, 0, len(msgs))
+	for _, v := range msgs {
+		hits = append(hits, SearchHit{Message: v, Anchor: msgAnchor(q.UserId, v)})
+	}
+	return hits, total, 0, "ok"
+}
+
+// msgAnchor 从搜索人的视角生成消息在历史记录中的锚点
+func msgAnchor(userId uint, msg Message) HistoryAnchor {
+	anchor := HistoryAnchor{
+		ConvKey:    convKey(msg),
+		TargetType: msg.Type,
+		TargetId:   msg.TargetId,
+		ThreadId:   msg.ThreadId,
+		Seq:        msg.Seq,
+	}
+	if msg.Type == 1 && msg.TargetId == int64(userId) {
+		anchor.TargetId = msg.UserId
+	}
+	return anchor
+}
+
+// RedisMsgAround 获取锚点序号前后的历史消息
+// @Param seq	锚点序号
+// @Param count	前后各取多少条
+func RedisMsgAround(userIdA int64, userIdB int64, seq int64, count int64) []string {
+	min := seq - count
+	if min < 1 {
+		min = 1
+	}
+	max := seq + count
+	ctx := context.Background()
+	key := msgKey(userIdA, userIdB)
+	rels, err := utils.RedisCluster.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: strconv.FormatInt(min, 10),
+		Max: strconv.FormatInt(max, 10),
+	}).Result()
+	if err != nil {
+		fmt.Println(err)
+	}
+	// 缓存中最早的消息之前的部分 与 GroupMsgAround 一样从MySQL补齐
+	oldest := max + 1
+	if zs, err := utils.RedisCluster.ZRangeWithScores(ctx, key, 0, 0).Result(); err == nil && len(zs) > 0 {
+		oldest = int64(zs[0].Score)
+	}
+	if min < oldest {
+		msgs := make([]Message, 0)
+		utils.DB.Unscoped().Where("type = 1 and thread_id = 0 and ((user_id = ? and target_id = ?) or (user_id = ? and target_id = ?))",
+			userIdA, userIdB, userIdB, userIdA).
+			Where("seq between ? and ?", min, oldest-1).Order("seq").Find(&msgs)
+		rels = append(marshalMsgs(msgs), rels...)
+	}
+	return attachReactions(filterHiddenMsg(uint(userIdA), rels))
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"GinChat/utils"
+	"context"
+	"fmt"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Thread 群聊中的话题  以一条群消息为根 拥有独立的序号和未读数
+type Thread struct {
+	gorm.Model
+	GroupId   uint //所属群
+	RootMsgId uint //话题的根消息
+	CreatorId uint //发起人
+	Title     string
+}
+
+func (table *Thread) TableName() string {
+	return "thread"
+}
+
+// ThreadInfo 话题列表项 附带当前用户的未读数
+type ThreadInfo struct {
+	Thread
+	LastSeq int64 //话题最新序号
+	Unread  int64 //未读数
+}
+
+// FindThreadByID 根据ID查找话题
+func FindThreadByID(threadId uint) Thread {
+	thread := Thread{}
+	utils.DB.Where("id = ?", threadId).Find(&thread)
+	return thread
+}
+
+// CreateThread 以某条群消息为根发起话题  同一条消息只会有一个话题
+func CreateThread(userId uint, rootMsgId uint, title string) (Thread, int, string) {
+	thread := Thread{}
+	root, err := FindMsgByID(rootMsgId)
+	if err != nil || root.DeletedAt.Valid {
+		return thread, -1, "消息不存在"
+	}
+	if root.Type != 2 || root.ThreadId != 0 {
+		return thread, -1, "只能对群聊主时间线上的消息发起话题"
+	}
+	if !IsMsgVisible(userId, root) {
+		return thread, -1, "不是群成员"
+	}
+	if community := findCommunity(uint(root.TargetId)); community.Type == GroupChannel && !community.AllowComment {
+		return thread, -1, "频道未开启评论"
+	}
+	utils.DB.Where("root_msg_id = ?", rootMsgId).Find(&thread)
+	if thread.ID != 0 {
+		return thread, 0, "话题已存在"
+	}
+	if title == "" {
+		title = msgSnippet(root)
+	}
+	thread = Thread{
+		GroupId:   uint(root.TargetId),
+		RootMsgId: rootMsgId,
+		CreatorId: userId,
+		Title:     title,
+	}
+	if err := utils.DB.Create(&thread).Error; err != nil {
+		return thread, -1, "发起话题失败"
+	}
+	return thread, 0, "发起话题成功"
+}
+
+// LoadThreads 群内的话题列表 及当前用户在每个话题中的未读数
+func LoadThreads(userId uint, groupId uint) []ThreadInfo {
+	threads := make([]Thread, 0)
+	utils.DB.Where("group_id = ?", groupId).Order("updated_at desc").Find(&threads)
+	ctx := context.Background()
+	readKey := threadReadKey(userId)
+	res := make([]ThreadInfo, 0, len(threads))
+	for _, v := range threads {
+		info := ThreadInfo{Thread: v}
+		info.LastSeq, _ = utils.RedisCluster.Get(ctx, "seq_"+threadKey(v.ID)).Int64()
+		readSeq, _ := utils.RedisCluster.HGet(ctx, readKey, strconv.Itoa(int(v.ID))).Int64()
+		info.Unread = info.LastSeq - readSeq
+		res = append(res, info)
+	}
+	return res
+}
+
+// ThreadMsg 获取话题内的历史消息 并把该话题标记为已读
+// @Param start & end	消息获取idx范围
+// @Param isRev			是否需要反转
+func ThreadMsg(userId uint, threadId uint, start int64, end int64, isRev bool) ([]string, int, string) {
+	thread := FindThreadByID(threadId)
+	if thread.ID == 0 {
+		return nil, -1, "话题不存在"
+	}
+	floor, ok := groupHistoryFloor(userId, thread.GroupId)
+	if !ok {
+		return nil, -1, "不是群成员"
+	}
+	// 与 canSeeGroupMsg 一样 话题以根消息在群时间线上的位置判断  入群前的话题看不到
+	if floor > 0 {
+		root, err := FindMsgByID(thread.RootMsgId)
+		if err != nil || root.Seq <= floor {
+			return nil, -1, "无权查看此话题"
+		}
+	}
+	ctx := context.Background()
+	key := threadKey(threadId)
+	var rels []string
+	var err error
+	if isRev {
+		rels, err = utils.RedisCluster.ZRange(ctx, key, start, end).Result()
+	} else {
+		rels, err = utils.RedisCluster.ZRevRange(ctx, key, start, end).Result()
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	lastSeq, _ := utils.RedisCluster.Get(ctx, "seq_"+key).Int64()
+	utils.RedisCluster.HSet(ctx, threadReadKey(userId), strconv.Itoa(int(threadId)), lastSeq)
+	return attachReactions(filterHiddenMsg(userId, rels)), 0, "ok"
+}
+
+func threadKey(threadId uint) string {
+	return "thread_" + strconv.Itoa(int(threadId))
+}
+
+// threadReadKey 用户在各话题中已读到的序号
+func threadReadKey(userId uint) string {
+	return "thread_read_" + strconv.Itoa(int(userId))
+}
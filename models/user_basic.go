@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
@@ -111,3 +112,13 @@ func UpdateUser(user UserBasic) *gorm.DB {
 			Avatar:   user.Avatar,
 		})
 }
+
+// IsSystemAdmin 是否为系统管理员  管理员ID在配置 admin.ids 中
+func IsSystemAdmin(userId uint) bool {
+	for _, v := range viper.GetIntSlice("admin.ids") {
+		if uint(v) == userId {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,10 @@ package models
 import (
 	"GinChat/utils"
 	"context"
+	"fmt"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 // SetUserOnlineInfo 设置在线用户到redis缓存
@@ -11,3 +14,19 @@ func SetUserOnlineInfo(key string, val []byte, timeTTL time.Duration) {
 	ctx := context.Background()
 	utils.RedisCluster.Set(ctx, key, val, timeTTL)
 }
+
+// clearOnlineScript 只删除仍属于这个连接的在线状态  用户已在别处重新连接时保留
+var clearOnlineScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ClearUserOnlineInfo 连接断开时删除在线状态
+func ClearUserOnlineInfo(key string, val []byte) {
+	ctx := context.Background()
+	if err := clearOnlineScript.Run(ctx, utils.RedisCluster, []string{key}, val).Err(); err != nil {
+		fmt.Println(err)
+	}
+}
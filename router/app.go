@@ -29,11 +29,19 @@ func Router() *gin.Engine {
 	r.GET("/toChat", service.ToChat)
 	r.GET("/chat", service.Chat)
 	r.POST("/searchFriends", service.SearchFriends)
+	//搜索用户和公开群
+	r.POST("/discover/users", service.DiscoverUsers)
+	r.POST("/discover/groups", service.DiscoverGroups)
 
 	//用户模块
 	r.POST("/user/getUserList", service.GetUserList)
 	r.POST("/user/createUser", service.CreateUser)
 	r.POST("/user/deleteUser", service.DeleteUser)
+	r.POST("/user/cancelDelete", service.CancelDeleteUser)
+	r.POST("/user/exportData", service.ExportUserData)
+	//隐私设置
+	r.POST("/user/privacy", service.FindPrivacy)
+	r.POST("/user/setPrivacy", service.SetPrivacy)
 	r.POST("/user/updateUser", service.UpdateUser)
 	r.POST("/user/findUserByNameAndPwd", service.FindUserByNameAndPwd)
 	r.POST("/user/find", service.FindByID)
@@ -43,6 +51,18 @@ func Router() *gin.Engine {
 	r.GET("/user/sendUserMsg", service.SendUserMsg)
 	//添加好友
 	r.POST("/contact/addfriend", service.AddFriend)
+	//好友申请
+	r.POST("/contact/handleFriendRequest", service.HandleFriendRequest)
+	r.POST("/contact/friendRequests", service.LoadFriendRequests)
+	//黑名单
+	r.POST("/contact/block", service.BlockUser)
+	r.POST("/contact/blocked", service.LoadBlocked)
+	//删除好友 备注和标签
+	r.POST("/contact/deleteFriend", service.DeleteFriend)
+	r.POST("/contact/remark", service.SetRemark)
+	r.POST("/contact/setTags", service.SetContactTags)
+	r.POST("/contact/tags", service.LoadContactTags)
+	r.POST("/contact/deleteTag", service.DeleteContactTag)
 	//上传文件
 	r.POST("/attach/upload", service.Upload)
 	//创建群
@@ -50,8 +70,77 @@ func Router() *gin.Engine {
 	//群列表
 	r.POST("/contact/loadcommunity", service.LoadCommunity)
 	r.POST("/contact/joinGroup", service.JoinGroups)
+	//群成员列表 在线人数与群昵称
+	r.POST("/contact/groupMembers", service.GroupMembers)
+	r.POST("/contact/onlineCount", service.GroupOnlineCount)
+	r.POST("/contact/groupNickname", service.SetGroupNickname)
+	//群资料 设置与公告
+	r.POST("/contact/updateCommunity", service.UpdateCommunity)
+	r.POST("/contact/groupSettings", service.SetGroupSettings)
+	r.POST("/contact/announce", service.CreateAnnouncement)
+	r.POST("/contact/deleteAnnouncement", service.DeleteAnnouncement)
+	r.POST("/contact/announcements", service.LoadAnnouncements)
+	r.POST("/contact/ackAnnouncement", service.AckAnnouncement)
+	//禁言 慢速模式与管理记录
+	r.POST("/contact/muteMember", service.MuteMember)
+	r.POST("/contact/slowMode", service.SetSlowMode)
+	r.POST("/contact/auditLogs", service.AuditLogs)
+	//入群审核与邀请
+	r.POST("/contact/joinRequests", service.JoinRequests)
+	r.POST("/contact/handleJoinRequest", service.HandleJoinRequest)
+	r.POST("/contact/invite", service.InviteMember)
+	r.POST("/contact/createInvite", service.CreateInvite)
+	r.POST("/contact/joinByInvite", service.JoinByInvite)
+	r.POST("/contact/setJoinPolicy", service.SetJoinPolicy)
+	//退群 踢人 管理员 转让群主
+	r.POST("/contact/leaveGroup", service.LeaveGroup)
+	r.POST("/contact/kickMember", service.KickMember)
+	r.POST("/contact/setAdmin", service.SetGroupAdmin)
+	r.POST("/contact/transferOwner", service.TransferOwner)
+	r.POST("/contact/muteGroup", service.MuteGroup)
+	//频道订阅
+	r.POST("/channel/subscribe", service.Subscribe)
+	r.POST("/channel/unsubscribe", service.Unsubscribe)
+	r.POST("/channel/list", service.LoadChannels)
+	r.POST("/channel/posts", service.ChannelPosts)
+	r.POST("/channel/view", service.ViewChannelPosts)
+	r.POST("/contact/setRetention", service.SetGroupRetention)
 	//心跳续命 不合适  因为Node  所以前端发过来的消息再receProc里面处理
 	// r.POST("/user/heartbeat", service.Heartbeat)
 	r.POST("/user/redisMsg", service.RedisMsg)
+	//删除消息
+	r.POST("/message/delete", service.DeleteMsg)
+	//群话题
+	r.POST("/message/createThread", service.CreateThread)
+	r.POST("/message/threads", service.LoadThreads)
+	//表情回应
+	r.POST("/message/react", service.ToggleReaction)
+	//@我的
+	r.POST("/message/mentions", service.LoadMentions)
+	r.POST("/message/readMentions", service.ReadMentions)
+	//转发
+	r.POST("/message/forward", service.ForwardMsg)
+	//置顶与收藏
+	r.POST("/message/pin", service.PinMsg)
+	r.POST("/message/pinned", service.LoadPinned)
+	r.POST("/message/star", service.StarMsg)
+	r.POST("/message/starred", service.LoadStarred)
+	//定时消息
+	r.POST("/message/schedule", service.CreateScheduledMsg)
+	r.POST("/message/scheduled", service.LoadScheduledMsgs)
+	r.POST("/message/cancelSchedule", service.CancelScheduledMsg)
+	//阅后即焚
+	r.POST("/message/setDisappear", service.SetDisappear)
+	r.POST("/message/read", service.ReadMsg)
+	//搜索聊天记录
+	r.POST("/message/search", service.SearchMsg)
+	//聊天记录导出与导入
+	r.POST("/message/export", service.StartExport)
+	r.POST("/message/import", service.StartImport)
+	r.POST("/message/archiveJob", service.ArchiveJob)
+	r.GET("/message/exportDownload", service.DownloadExport)
+	//管理
+	r.POST("/admin/compact", service.CompactMessages)
+	r.POST("/admin/retentionReport", service.RetentionReport)
 	return r
 }
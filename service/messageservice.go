@@ -0,0 +1,395 @@
+package service
+
+import (
+	"GinChat/models"
+	"GinChat/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeleteMsg 删除消息  scope=me 仅自己  scope=all 所有人
+func DeleteMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	msgId, _ := strconv.Atoi(c.Request.FormValue("msgId"))
+	var code int
+	var msg string
+	if c.Request.FormValue("scope") == "all" {
+		code, msg = models.DeleteMsgForAll(uint(userId), uint(msgId))
+	} else {
+		code, msg = models.DeleteMsgForMe(uint(userId), uint(msgId))
+	}
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// CreateThread 以某条群消息为根发起话题
+func CreateThread(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	msgId, _ := strconv.Atoi(c.Request.FormValue("msgId"))
+	thread, code, msg := models.CreateThread(uint(userId), uint(msgId), c.Request.FormValue("title"))
+	if code == 0 {
+		utils.RespOK(c.Writer, thread, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadThreads 群内话题列表
+func LoadThreads(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	if !models.IsGroupMember(uint(userId), uint(groupId)) {
+		utils.RespFail(c.Writer, "不是群成员")
+		return
+	}
+	data := models.LoadThreads(uint(userId), uint(groupId))
+	utils.RespOKList(c.Writer, data, len(data))
+}
+
+// ToggleReaction 对消息回应表情 再次提交同一表情即取消
+func ToggleReaction(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	msgId, _ := strconv.Atoi(c.Request.FormValue("msgId"))
+	data, code, msg := models.ToggleReaction(uint(userId), uint(msgId), c.Request.FormValue("emoji"))
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadMentions @我的消息列表
+func LoadMentions(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	page, size := parsePage(c)
+	data, total := models.LoadMentions(uint(userId), page, size)
+	utils.RespOKList(c.Writer, data, total)
+}
+
+// ReadMentions 清空某个群里@我的未读数
+func ReadMentions(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	models.ReadMentions(uint(userId), uint(groupId))
+	utils.RespOK(c.Writer, 0, "ok")
+}
+
+// ForwardMsg 转发消息  msgIds 逗号分隔  merge=true 合并转发
+func ForwardMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetType, _ := strconv.Atoi(c.Request.FormValue("targetType"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	merge, _ := strconv.ParseBool(c.Request.FormValue("merge"))
+	data, code, msg := models.ForwardMsg(uint(userId), parseIds(c.Request.FormValue("msgIds")), targetType, int64(targetId), merge)
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// PinMsg 置顶消息  unpin=true 取消置顶
+func PinMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	msgId, _ := strconv.Atoi(c.Request.FormValue("msgId"))
+	unpin, _ := strconv.ParseBool(c.Request.FormValue("unpin"))
+	var code int
+	var msg string
+	if unpin {
+		code, msg = models.UnpinMsg(uint(userId), uint(msgId))
+	} else {
+		code, msg = models.PinMsg(uint(userId), uint(msgId))
+	}
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadPinned 会话的置顶消息  targetType 1私聊 2群聊
+func LoadPinned(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetType, _ := strconv.Atoi(c.Request.FormValue("targetType"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	data, code, msg := models.LoadPinned(uint(userId), targetType, int64(targetId))
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, len(data))
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// StarMsg 收藏消息  unstar=true 取消收藏
+func StarMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	msgId, _ := strconv.Atoi(c.Request.FormValue("msgId"))
+	unstar, _ := strconv.ParseBool(c.Request.FormValue("unstar"))
+	var code int
+	var msg string
+	if unstar {
+		code, msg = models.UnstarMsg(uint(userId), uint(msgId))
+	} else {
+		code, msg = models.StarMsg(uint(userId), uint(msgId))
+	}
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadStarred 我的收藏
+func LoadStarred(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	page, size := parsePage(c)
+	data, total := models.LoadStarred(uint(userId), page, size)
+	utils.RespOKList(c.Writer, data, total)
+}
+
+// CreateScheduledMsg 定时发送消息  fireAt 为发送时间的unix秒
+func CreateScheduledMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	fireAt, _ := strconv.ParseInt(c.Request.FormValue("fireAt"), 10, 64)
+	msg := models.Message{}
+	msg.TargetId, _ = strconv.ParseInt(c.Request.FormValue("targetId"), 10, 64)
+	msg.Type, _ = strconv.Atoi(c.Request.FormValue("type"))
+	msg.Media, _ = strconv.Atoi(c.Request.FormValue("media"))
+	msg.Content = c.Request.FormValue("content")
+	msg.Url = c.Request.FormValue("url")
+	data, code, info := models.CreateScheduledMsg(uint(userId), msg, fireAt)
+	if code == 0 {
+		utils.RespOK(c.Writer, data, info)
+	} else {
+		utils.RespFail(c.Writer, info)
+	}
+}
+
+// LoadScheduledMsgs 我的定时消息
+func LoadScheduledMsgs(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	data := models.LoadScheduledMsgs(uint(userId))
+	utils.RespOKList(c.Writer, data, len(data))
+}
+
+// CancelScheduledMsg 取消定时消息
+func CancelScheduledMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	jobId, _ := strconv.ParseInt(c.Request.FormValue("jobId"), 10, 64)
+	code, msg := models.CancelScheduledMsg(uint(userId), jobId)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetDisappear 设置会话阅后即焚  ttl 秒 0关闭  mode 1发送后 2读取后
+func SetDisappear(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetType, _ := strconv.Atoi(c.Request.FormValue("targetType"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	ttl, _ := strconv.Atoi(c.Request.FormValue("ttl"))
+	mode, _ := strconv.Atoi(c.Request.FormValue("mode"))
+	code, msg := models.SetDisappear(uint(userId), targetType, int64(targetId), ttl, mode)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// ReadMsg 标记消息已读  msgIds 逗号分隔
+func ReadMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	for _, id := range parseIds(c.Request.FormValue("msgIds")) {
+		models.ReadMsg(uint(userId), id)
+	}
+	utils.RespOK(c.Writer, 0, "ok")
+}
+
+// SearchMsg 搜索聊天记录  可按会话 发送者 消息类型 时间范围过滤
+func SearchMsg(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	q := models.SearchQuery{
+		UserId:  uint(userId),
+		Keyword: c.Request.FormValue("keyword"),
+	}
+	q.TargetType, _ = strconv.Atoi(c.Request.FormValue("targetType"))
+	q.TargetId, _ = strconv.ParseInt(c.Request.FormValue("targetId"), 10, 64)
+	q.SenderId, _ = strconv.ParseInt(c.Request.FormValue("senderId"), 10, 64)
+	q.Media, _ = strconv.Atoi(c.Request.FormValue("media"))
+	q.StartTime, _ = strconv.ParseUint(c.Request.FormValue("startTime"), 10, 64)
+	q.EndTime, _ = strconv.ParseUint(c.Request.FormValue("endTime"), 10, 64)
+	q.Page, q.Size = parsePage(c)
+	data, total, code, msg := models.SearchMsg(q)
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, total)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// StartExport 导出聊天记录  targetType 1私聊 2群聊 不传则导出整个账号
+func StartExport(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetType, _ := strconv.Atoi(c.Request.FormValue("targetType"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	data, code, msg := models.StartExport(uint(userId), targetType, int64(targetId))
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// StartImport 上传导出包并还原聊天记录
+func StartImport(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	file, err := c.FormFile("file")
+	if err != nil {
+		utils.RespFail(c.Writer, err.Error())
+		return
+	}
+	dir := models.ExportDir()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		utils.RespFail(c.Writer, err.Error())
+		return
+	}
+	dst := filepath.Join(dir, "import_"+strconv.Itoa(userId)+"_"+strconv.FormatInt(time.Now().UnixNano(), 10)+".zip")
+	if err = c.SaveUploadedFile(file, dst); err != nil {
+		utils.RespFail(c.Writer, err.Error())
+		return
+	}
+	data, code, msg := models.StartImport(uint(userId), dst)
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// ArchiveJob 查询导出/导入任务进度
+func ArchiveJob(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	data, code, msg := models.FindArchiveJob(uint(userId), c.Request.FormValue("jobId"))
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// DownloadExport 下载导出包
+func DownloadExport(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	jobId := c.Request.FormValue("jobId")
+	file, code, msg := models.ArchiveFile(uint(userId), jobId)
+	if code != 0 {
+		utils.RespFail(c.Writer, msg)
+		return
+	}
+	c.FileAttachment(file, "ginchat_"+jobId+".zip")
+}
+
+// parsePage 解析分页参数 默认第1页 每页20条
+func parsePage(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.Request.FormValue("page"))
+	size, _ := strconv.Atoi(c.Request.FormValue("size"))
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 || size > 100 {
+		size = 20
+	}
+	return page, size
+}
+
+// formBool 解析布尔参数  没有传该参数时返回nil
+func formBool(c *gin.Context, name string) *bool {
+	c.Request.FormValue(name)
+	if !c.Request.Form.Has(name) {
+		return nil
+	}
+	v, _ := strconv.ParseBool(c.Request.FormValue(name))
+	return &v
+}
+
+// parseIds 解析逗号分隔的ID列表 并去重
+func parseIds(str string) []uint {
+	ids := make([]uint, 0)
+	seen := make(map[int]bool)
+	for _, v := range strings.Split(str, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil && id > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// Subscribe 订阅频道
+func Subscribe(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	channelId, _ := strconv.Atoi(c.Request.FormValue("channelId"))
+	code, msg := models.Subscribe(uint(userId), uint(channelId))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// Unsubscribe 取消订阅频道
+func Unsubscribe(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	channelId, _ := strconv.Atoi(c.Request.FormValue("channelId"))
+	code, msg := models.Unsubscribe(uint(userId), uint(channelId))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadChannels 订阅的频道列表 附带未读数
+func LoadChannels(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	data := models.LoadChannels(uint(userId))
+	utils.RespOKList(c.Writer, data, len(data))
+}
+
+// ChannelPosts 拉取频道消息  afterSeq 之后的 limit 条
+func ChannelPosts(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	channelId, _ := strconv.Atoi(c.Request.FormValue("channelId"))
+	afterSeq, _ := strconv.ParseInt(c.Request.FormValue("afterSeq"), 10, 64)
+	limit, _ := strconv.ParseInt(c.Request.FormValue("limit"), 10, 64)
+	data, code, msg := models.ChannelPosts(uint(userId), uint(channelId), afterSeq, limit)
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, len(data))
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// ViewChannelPosts 上报频道消息阅读  msgIds 逗号分隔
+func ViewChannelPosts(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	channelId, _ := strconv.Atoi(c.Request.FormValue("channelId"))
+	code, msg := models.ViewChannelPosts(uint(userId), uint(channelId), parseIds(c.Request.FormValue("msgIds")))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
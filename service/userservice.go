@@ -8,6 +8,7 @@ import (
 	"gorm.io/gorm"
 	"math/rand"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
@@ -143,22 +144,65 @@ func FindUserByNameAndPwd(c *gin.Context) {
 }
 
 // DeleteUser
-// @Summary 删除用户
+// @Summary 申请注销用户 冷静期后执行
 // @Tags 用户模块
 // @param id query string false "id"
 // @Success 200 {string} json{"code","message"}
-// @Router /user/deleteUser [get]
+// @Router /user/deleteUser [post]
 func DeleteUser(c *gin.Context) {
-	user := models.UserBasic{}
-	id, _ := strconv.Atoi(c.Query("id"))
-	user.ID = uint(id)
-	models.DeleteUser(user)
+	id, _ := strconv.Atoi(c.Request.FormValue("id"))
+	data, code, msg := models.RequestAccountDeletion(uint(id))
 	c.JSON(200, gin.H{
-		"code":    0, //  0成功   -1失败
-		"message": "删除用户成功！",
-		"data":    user,
+		"code":    code, //  0成功   -1失败
+		"message": msg,
+		"data":    data,
 	})
+}
+
+// CancelDeleteUser 冷静期内撤销注销
+func CancelDeleteUser(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Request.FormValue("id"))
+	code, msg := models.CancelAccountDeletion(uint(id))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// ExportUserData 导出与用户相关的全部数据
+func ExportUserData(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Request.FormValue("id"))
+	token := c.Request.FormValue("token")
+	data, code, msg := models.ExportUserData(uint(id), token)
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
 
+// FindPrivacy 查询隐私设置
+func FindPrivacy(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	utils.RespOK(c.Writer, models.FindPrivacy(uint(userId)), "ok")
+}
+
+// SetPrivacy 修改隐私设置  0所有人 1仅好友 2任何人都不可以  addMe只能是0或2
+func SetPrivacy(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	setting := models.PrivacySetting{UserId: uint(userId)}
+	setting.FindByName, _ = strconv.Atoi(c.Request.FormValue("findByName"))
+	setting.FindByPhone, _ = strconv.Atoi(c.Request.FormValue("findByPhone"))
+	setting.FindByEmail, _ = strconv.Atoi(c.Request.FormValue("findByEmail"))
+	setting.AddMe, _ = strconv.Atoi(c.Request.FormValue("addMe"))
+	setting.OnlineStatus, _ = strconv.Atoi(c.Request.FormValue("onlineStatus"))
+	code, msg := models.SetPrivacy(setting)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
 }
 
 // UpdateUser
@@ -287,6 +331,41 @@ func RedisMsg(c *gin.Context) {
 	start, _ := strconv.Atoi(c.PostForm("start"))
 	end, _ := strconv.Atoi(c.PostForm("end"))
 	isRev, _ := strconv.ParseBool(c.PostForm("isRev"))
+	// 话题的历史消息
+	threadId, _ := strconv.Atoi(c.PostForm("threadId"))
+	if threadId != 0 {
+		res, code, msg := models.ThreadMsg(uint(userIdA), uint(threadId), int64(start), int64(end), isRev)
+		if code != 0 {
+			utils.RespFail(c.Writer, msg)
+			return
+		}
+		utils.RespOKList(c.Writer, "ok", res)
+		return
+	}
+	// 从搜索结果的锚点跳转 取锚点前后的消息
+	anchorSeq, _ := strconv.ParseInt(c.PostForm("anchorSeq"), 10, 64)
+	// 群的历史消息 type=2 时 userIdB 为群ID
+	if c.PostForm("type") == "2" {
+		var res []string
+		var code int
+		var msg string
+		if anchorSeq != 0 {
+			res, code, msg = models.GroupMsgAround(uint(userIdA), uint(userIdB), anchorSeq, 10)
+		} else {
+			res, code, msg = models.GroupMsg(uint(userIdA), uint(userIdB), int64(start), int64(end), isRev)
+		}
+		if code != 0 {
+			utils.RespFail(c.Writer, msg)
+			return
+		}
+		utils.RespOKList(c.Writer, "ok", res)
+		return
+	}
+	if anchorSeq != 0 {
+		res := models.RedisMsgAround(int64(userIdA), int64(userIdB), anchorSeq, 10)
+		utils.RespOKList(c.Writer, "ok", res)
+		return
+	}
 	res := models.RedisMsg(int64(userIdA), int64(userIdB), int64(start), int64(end), isRev)
 	utils.RespOKList(c.Writer, "ok", res)
 }
@@ -295,18 +374,122 @@ func SendUserMsg(c *gin.Context) {
 	models.Chat(c.Writer, c.Request)
 }
 
-// SearchFriends 查找好友列表
+// SearchFriends 查找好友列表  tag 按标签筛选
 func SearchFriends(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Request.FormValue("userId"))
-	users := models.SearchFriend(uint(id))
+	users := models.SearchFriend(uint(id), c.Request.FormValue("tag"))
 	utils.RespOKList(c.Writer, users, len(users))
 }
 
+// DiscoverUsers 搜索用户  keyword 为名字前缀 手机号或邮箱
+func DiscoverUsers(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	page, size := parsePage(c)
+	data, total := models.DiscoverUsers(uint(userId), c.Request.FormValue("keyword"), page, size)
+	utils.RespOKList(c.Writer, data, total)
+}
+
+// DiscoverGroups 搜索公开群  按群名和简介匹配
+func DiscoverGroups(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	page, size := parsePage(c)
+	data, total := models.DiscoverGroups(uint(userId), c.Request.FormValue("keyword"), page, size)
+	utils.RespOKList(c.Writer, data, total)
+}
+
+// DeleteFriend 删除好友  clearHistory 同时清空自己这边的聊天记录
+func DeleteFriend(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	clearHistory, _ := strconv.ParseBool(c.Request.FormValue("clearHistory"))
+	code, msg := models.DeleteFriend(uint(userId), uint(targetId), clearHistory)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetRemark 设置好友备注名
+func SetRemark(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	code, msg := models.SetRemark(uint(userId), uint(targetId), strings.TrimSpace(c.Request.FormValue("remark")))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetContactTags 设置好友标签  tags 逗号分隔 为空时清除
+func SetContactTags(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	code, msg := models.SetContactTags(uint(userId), uint(targetId), strings.Split(c.Request.FormValue("tags"), ","))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadContactTags 标签列表
+func LoadContactTags(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	tags := models.LoadContactTags(uint(userId))
+	utils.RespOKList(c.Writer, tags, len(tags))
+}
+
+// DeleteContactTag 删除标签
+func DeleteContactTag(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	code, msg := models.DeleteContactTag(uint(userId), c.Request.FormValue("name"))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
 // AddFriend 添加好友
 func AddFriend(c *gin.Context) {
 	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
 	targetName := c.Request.FormValue("targetName")
-	code, msg := models.AddFriend(uint(userId), targetName)
+	greeting := c.Request.FormValue("greeting")
+	code, msg := models.AddFriend(uint(userId), targetName, greeting)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// HandleFriendRequest 处理好友申请  accept true同意 false拒绝
+func HandleFriendRequest(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	requestId, _ := strconv.Atoi(c.Request.FormValue("requestId"))
+	accept, _ := strconv.ParseBool(c.Request.FormValue("accept"))
+	var code int
+	var msg string
+	if accept {
+		code, msg = models.AcceptFriendRequest(uint(userId), uint(requestId))
+	} else {
+		code, msg = models.RejectFriendRequest(uint(userId), uint(requestId))
+	}
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// BlockUser 拉黑/取消拉黑  block true拉黑 false取消
+func BlockUser(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	block, _ := strconv.ParseBool(c.Request.FormValue("block"))
+	code, msg := models.BlockUser(uint(userId), uint(targetId), block)
 	if code == 0 {
 		utils.RespOK(c.Writer, code, msg)
 	} else {
@@ -314,13 +497,30 @@ func AddFriend(c *gin.Context) {
 	}
 }
 
-// CreateCommunity 新建群
+// LoadBlocked 黑名单列表
+func LoadBlocked(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	users := models.LoadBlocked(uint(userId))
+	utils.RespOKList(c.Writer, users, len(users))
+}
+
+// LoadFriendRequests 好友申请列表  outgoing true 我发出的申请 默认收到的申请
+func LoadFriendRequests(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	outgoing, _ := strconv.ParseBool(c.Request.FormValue("outgoing"))
+	res := models.LoadFriendRequests(uint(userId), !outgoing)
+	utils.RespOKList(c.Writer, res, len(res))
+}
+
+// CreateCommunity 新建群  type 0普通群 1广播频道 2部门群
 func CreateCommunity(c *gin.Context) {
 	ownerId, _ := strconv.Atoi(c.Request.FormValue("ownerId"))
-	community := models.Community{
+	groupType, _ := strconv.Atoi(c.Request.FormValue("type"))
+	community := models.GroupBasic{
 		OwnerId: uint(ownerId),
 		Name:    c.Request.FormValue("name"),
-		Img:     c.Request.FormValue("img"),
+		Icon:    c.Request.FormValue("icon"),
+		Type:    groupType,
 		Desc:    c.Request.FormValue("desc"),
 	}
 	code, msg := models.CreateCommunity(community)
@@ -349,7 +549,7 @@ func JoinGroups(c *gin.Context) {
 	comId := c.Request.FormValue("comId")
 
 	//	name := c.Request.FormValue("name")
-	data, msg := models.JoinGroup(uint(userId), comId)
+	data, msg := models.JoinGroup(uint(userId), comId, c.Request.FormValue("reason"))
 	if data == 0 {
 		utils.RespOK(c.Writer, data, msg)
 	} else {
@@ -357,6 +557,341 @@ func JoinGroups(c *gin.Context) {
 	}
 }
 
+// JoinRequests 待审核的入群申请
+func JoinRequests(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	data, code, msg := models.LoadJoinRequests(uint(userId), uint(groupId))
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, len(data))
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// HandleJoinRequest 审核入群申请  approve=true 同意
+func HandleJoinRequest(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	requestId, _ := strconv.Atoi(c.Request.FormValue("requestId"))
+	approve, _ := strconv.ParseBool(c.Request.FormValue("approve"))
+	code, msg := models.HandleJoinRequest(uint(userId), uint(requestId), approve)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// InviteMember 邀请好友入群
+func InviteMember(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	code, msg := models.InviteMember(uint(userId), uint(groupId), uint(targetId))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// CreateInvite 生成入群邀请码  hours 有效期 单位小时
+func CreateInvite(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	hours, _ := strconv.Atoi(c.Request.FormValue("hours"))
+	data, code, msg := models.CreateInvite(uint(userId), uint(groupId), hours)
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// JoinByInvite 通过邀请码入群
+func JoinByInvite(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	code, msg := models.JoinByInvite(uint(userId), strings.TrimSpace(c.Request.FormValue("code")))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetJoinPolicy 设置入群方式  policy 0直接加入 1需要审核 2仅邀请  maxMembers 人数上限
+func SetJoinPolicy(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	policy, _ := strconv.Atoi(c.Request.FormValue("policy"))
+	maxMembers, _ := strconv.Atoi(c.Request.FormValue("maxMembers"))
+	code, msg := models.SetJoinPolicy(uint(userId), uint(groupId), policy, maxMembers)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// UpdateCommunity 修改群资料  只修改传了值的字段
+func UpdateCommunity(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	code, msg := models.UpdateCommunity(uint(userId), uint(groupId),
+		strings.TrimSpace(c.Request.FormValue("name")), c.Request.FormValue("icon"), c.Request.FormValue("desc"))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// GroupMembers 群成员列表  分页 附带角色 群昵称 入群时间和在线状态
+func GroupMembers(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	page, size := parsePage(c)
+	data, total, code, msg := models.LoadGroupMembers(uint(userId), uint(groupId), page, size)
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, total)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// GroupOnlineCount 群在线人数
+func GroupOnlineCount(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	data, code, msg := models.GroupOnlineCount(uint(userId), uint(groupId))
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetGroupNickname 设置群昵称
+func SetGroupNickname(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	code, msg := models.SetGroupNickname(uint(userId), uint(groupId), strings.TrimSpace(c.Request.FormValue("nickname")))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetGroupSettings 修改群设置  只修改传了的参数
+// muteAll 全员禁言 memberInvite 成员可邀请 hideHistory 新成员不可见历史 allowComment 频道可评论 public 公开到群搜索
+func SetGroupSettings(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	settings := models.GroupSettings{
+		MuteAll:      formBool(c, "muteAll"),
+		MemberInvite: formBool(c, "memberInvite"),
+		HideHistory:  formBool(c, "hideHistory"),
+		AllowComment: formBool(c, "allowComment"),
+		Public:       formBool(c, "public"),
+	}
+	code, msg := models.SetGroupSettings(uint(userId), uint(groupId), settings)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// CreateAnnouncement 发布群公告  pin=true 置顶并要求成员确认
+func CreateAnnouncement(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	pin, _ := strconv.ParseBool(c.Request.FormValue("pin"))
+	data, code, msg := models.CreateAnnouncement(uint(userId), uint(groupId), strings.TrimSpace(c.Request.FormValue("content")), pin)
+	if code == 0 {
+		utils.RespOK(c.Writer, data, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// DeleteAnnouncement 删除群公告
+func DeleteAnnouncement(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	id, _ := strconv.Atoi(c.Request.FormValue("id"))
+	code, msg := models.DeleteAnnouncement(uint(userId), uint(id))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LoadAnnouncements 群公告列表
+func LoadAnnouncements(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	data, code, msg := models.LoadAnnouncements(uint(userId), uint(groupId))
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, len(data))
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// AckAnnouncement 确认已读群公告
+func AckAnnouncement(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	id, _ := strconv.Atoi(c.Request.FormValue("id"))
+	code, msg := models.AckAnnouncement(uint(userId), uint(id))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// MuteMember 禁言群成员  seconds 禁言秒数 0解除
+func MuteMember(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	seconds, _ := strconv.Atoi(c.Request.FormValue("seconds"))
+	code, msg := models.MuteMember(uint(userId), uint(groupId), uint(targetId), seconds)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetSlowMode 设置慢速模式  seconds 0关闭
+func SetSlowMode(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	seconds, _ := strconv.Atoi(c.Request.FormValue("seconds"))
+	code, msg := models.SetSlowMode(uint(userId), uint(groupId), seconds)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// AuditLogs 群管理操作记录
+func AuditLogs(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	page, size := parsePage(c)
+	data, total, code, msg := models.LoadAuditLogs(uint(userId), uint(groupId), page, size)
+	if code == 0 {
+		utils.RespOKList(c.Writer, data, total)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// LeaveGroup 退群
+func LeaveGroup(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	code, msg := models.LeaveGroup(uint(userId), uint(groupId))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// KickMember 移出群成员
+func KickMember(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	code, msg := models.KickMember(uint(userId), uint(groupId), uint(targetId))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetGroupAdmin 设置/取消管理员  admin=true 设为管理员
+func SetGroupAdmin(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	admin, _ := strconv.ParseBool(c.Request.FormValue("admin"))
+	code, msg := models.SetMemberRole(uint(userId), uint(groupId), uint(targetId), admin)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// TransferOwner 转让群主
+func TransferOwner(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	targetId, _ := strconv.Atoi(c.Request.FormValue("targetId"))
+	code, msg := models.TransferOwner(uint(userId), uint(groupId), uint(targetId))
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// MuteGroup 设置群免打扰  mute=true 开启
+func MuteGroup(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	mute, _ := strconv.ParseBool(c.Request.FormValue("mute"))
+	code, msg := models.SetGroupMute(uint(userId), uint(groupId), mute)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// SetGroupRetention 设置群消息在Redis中的保留策略  count 条数 days 天数 0沿用全局配置
+func SetGroupRetention(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	groupId, _ := strconv.Atoi(c.Request.FormValue("groupId"))
+	count, _ := strconv.ParseInt(c.Request.FormValue("count"), 10, 64)
+	days, _ := strconv.Atoi(c.Request.FormValue("days"))
+	code, msg := models.SetGroupRetention(uint(userId), uint(groupId), count, days)
+	if code == 0 {
+		utils.RespOK(c.Writer, code, msg)
+	} else {
+		utils.RespFail(c.Writer, msg)
+	}
+}
+
+// CompactMessages 管理员手动触发消息整理
+func CompactMessages(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	if !models.IsSystemAdmin(uint(userId)) {
+		utils.RespFail(c.Writer, "没有权限")
+		return
+	}
+	go models.CompactMessages()
+	utils.RespOK(c.Writer, 0, "已开始整理")
+}
+
+// RetentionReport 最近一次消息整理报告
+func RetentionReport(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
+	if !models.IsSystemAdmin(uint(userId)) {
+		utils.RespFail(c.Writer, "没有权限")
+		return
+	}
+	utils.RespOK(c.Writer, models.LastCompactReport(), "ok")
+}
+
 // FindByID 根据用户Id查找用户
 func FindByID(c *gin.Context) {
 	userId, _ := strconv.Atoi(c.Request.FormValue("userId"))
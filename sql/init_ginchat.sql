@@ -1,16 +1,28 @@
 use ginchat;
-CREATE TABLE `communities` (
+CREATE TABLE `account_deletion` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
   `created_at` datetime(3) DEFAULT NULL,
   `updated_at` datetime(3) DEFAULT NULL,
   `deleted_at` datetime(3) DEFAULT NULL,
-  `name` longtext,
-  `owner_id` bigint(20) unsigned DEFAULT NULL,
-  `img` longtext,
-  `desc` longtext,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `execute_at` datetime(3) DEFAULT NULL,
+  `status` bigint(20) DEFAULT NULL,
   PRIMARY KEY (`id`),
-  KEY `idx_communities_deleted_at` (`deleted_at`)
-) ENGINE=InnoDB AUTO_INCREMENT=18 DEFAULT CHARSET=utf8;
+  KEY `idx_account_deletion_deleted_at` (`deleted_at`),
+  KEY `idx_account_deletion_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `announcement_ack` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `announcement_id` bigint(20) unsigned DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_announcement_ack_deleted_at` (`deleted_at`),
+  UNIQUE KEY `idx_announcement_ack` (`announcement_id`,`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `contact` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -25,6 +37,64 @@ CREATE TABLE `contact` (
   KEY `idx_contact_deleted_at` (`deleted_at`)
 ) ENGINE=InnoDB AUTO_INCREMENT=185 DEFAULT CHARSET=utf8;
 
+CREATE TABLE `contact_tag` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `owner_id` bigint(20) unsigned DEFAULT NULL,
+  `target_id` bigint(20) unsigned DEFAULT NULL,
+  `name` varchar(30) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_contact_tag_deleted_at` (`deleted_at`),
+  KEY `idx_contact_tag_owner_id` (`owner_id`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `friend_request` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `from_id` bigint(20) unsigned DEFAULT NULL,
+  `to_id` bigint(20) unsigned DEFAULT NULL,
+  `greeting` longtext,
+  `status` bigint(20) DEFAULT NULL,
+  `expire_at` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_friend_request_deleted_at` (`deleted_at`),
+  KEY `idx_friend_request_to_id` (`to_id`,`status`),
+  KEY `idx_friend_request_from_id` (`from_id`,`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `group_announcement` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `group_id` bigint(20) unsigned DEFAULT NULL,
+  `creator_id` bigint(20) unsigned DEFAULT NULL,
+  `content` longtext,
+  `pinned` tinyint(1) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_group_announcement_deleted_at` (`deleted_at`),
+  KEY `idx_group_announcement_group_id` (`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `group_audit_log` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `group_id` bigint(20) unsigned DEFAULT NULL,
+  `operator_id` bigint(20) unsigned DEFAULT NULL,
+  `target_id` bigint(20) unsigned DEFAULT NULL,
+  `action` longtext,
+  `detail` longtext,
+  PRIMARY KEY (`id`),
+  KEY `idx_group_audit_log_deleted_at` (`deleted_at`),
+  KEY `idx_group_audit_log_group_id` (`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 CREATE TABLE `group_basic` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
   `created_at` datetime(3) DEFAULT NULL,
@@ -35,27 +105,197 @@ CREATE TABLE `group_basic` (
   `icon` longtext,
   `type` bigint(20) DEFAULT NULL,
   `desc` longtext,
+  `join_policy` bigint(20) DEFAULT NULL,
+  `max_members` bigint(20) DEFAULT NULL,
+  `mute_all` tinyint(1) DEFAULT NULL,
+  `member_invite` tinyint(1) DEFAULT '1',
+  `hide_history` tinyint(1) DEFAULT NULL,
+  `slow_mode` bigint(20) DEFAULT NULL,
+  `allow_comment` tinyint(1) DEFAULT NULL,
+  `public` tinyint(1) DEFAULT NULL,
   PRIMARY KEY (`id`),
   KEY `idx_group_basic_deleted_at` (`deleted_at`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `group_join_request` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `group_id` bigint(20) unsigned DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `inviter_id` bigint(20) unsigned DEFAULT NULL,
+  `reason` longtext,
+  `status` bigint(20) DEFAULT NULL,
+  `handled_by` bigint(20) unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_group_join_request_deleted_at` (`deleted_at`),
+  KEY `idx_group_join_request_group_id` (`group_id`,`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `group_member` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `group_id` bigint(20) unsigned DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `role` bigint(20) DEFAULT NULL,
+  `joined_at` datetime(3) DEFAULT NULL,
+  `join_seq` bigint(20) DEFAULT NULL,
+  `mute_until` bigint(20) DEFAULT NULL,
+  `nickname` longtext,
+  PRIMARY KEY (`id`),
+  KEY `idx_group_member_deleted_at` (`deleted_at`),
+  UNIQUE KEY `idx_group_member` (`group_id`,`user_id`),
+  KEY `idx_group_member_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `message` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
   `created_at` datetime(3) DEFAULT NULL,
   `updated_at` datetime(3) DEFAULT NULL,
   `deleted_at` datetime(3) DEFAULT NULL,
-  `form_id` longtext,
-  `target_id` longtext,
-  `type` longtext,
+  `user_id` bigint(20) DEFAULT NULL,
+  `target_id` bigint(20) DEFAULT NULL,
+  `type` bigint(20) DEFAULT NULL,
   `media` bigint(20) DEFAULT NULL,
   `content` longtext,
+  `create_time` bigint(20) unsigned DEFAULT NULL,
+  `read_time` bigint(20) unsigned DEFAULT NULL,
   `pic` longtext,
   `url` longtext,
   `desc` longtext,
   `amount` bigint(20) DEFAULT NULL,
+  `seq` bigint(20) DEFAULT NULL,
+  `parent_id` bigint(20) unsigned DEFAULT NULL,
+  `quote` longtext,
+  `thread_id` bigint(20) unsigned DEFAULT NULL,
+  `mentions` longtext,
+  `mention_all` tinyint(1) DEFAULT NULL,
+  `forward_from` bigint(20) DEFAULT NULL,
+  `forward_msg_id` bigint(20) unsigned DEFAULT NULL,
+  `disappear_ttl` bigint(20) DEFAULT NULL,
+  `expire_at` bigint(20) unsigned DEFAULT NULL,
+  `archive` varchar(255) DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_message_deleted_at` (`deleted_at`),
+  KEY `idx_message_conv_seq` (`type`,`target_id`,`seq`),
+  KEY `idx_message_thread_seq` (`thread_id`,`seq`),
+  KEY `idx_message_parent_id` (`parent_id`),
+  FULLTEXT KEY `ft_message_content` (`content`) WITH PARSER ngram
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `conversation_setting` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `conv_key` varchar(64) DEFAULT NULL,
+  `disappear_ttl` bigint(20) DEFAULT NULL,
+  `disappear_mode` bigint(20) DEFAULT NULL,
+  `retention_count` bigint(20) DEFAULT NULL,
+  `retention_days` bigint(20) DEFAULT NULL,
+  `updated_by` bigint(20) unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_conversation_setting_deleted_at` (`deleted_at`),
+  UNIQUE KEY `idx_conversation_setting_conv_key` (`conv_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `mention` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `from_id` bigint(20) unsigned DEFAULT NULL,
+  `group_id` bigint(20) unsigned DEFAULT NULL,
+  `message_id` bigint(20) unsigned DEFAULT NULL,
+  `is_read` tinyint(1) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_mention_deleted_at` (`deleted_at`),
+  KEY `idx_mention_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `message_hidden` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `message_id` bigint(20) unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_message_hidden_deleted_at` (`deleted_at`),
+  UNIQUE KEY `idx_message_hidden_user_message` (`user_id`,`message_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `pinned_message` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `conv_key` varchar(64) DEFAULT NULL,
+  `message_id` bigint(20) unsigned DEFAULT NULL,
+  `pinned_by` bigint(20) unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_pinned_message_deleted_at` (`deleted_at`),
+  KEY `idx_pinned_message_conv_key` (`conv_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `privacy_setting` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `find_by_name` bigint(20) DEFAULT NULL,
+  `find_by_phone` bigint(20) DEFAULT NULL,
+  `find_by_email` bigint(20) DEFAULT NULL,
+  `add_me` bigint(20) DEFAULT NULL,
+  `online_status` bigint(20) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_privacy_setting_deleted_at` (`deleted_at`),
+  UNIQUE KEY `idx_privacy_setting_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `starred_message` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `message_id` bigint(20) unsigned DEFAULT NULL,
+  `conv_key` varchar(64) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_starred_message_deleted_at` (`deleted_at`),
+  KEY `idx_starred_message_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `thread` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `group_id` bigint(20) unsigned DEFAULT NULL,
+  `root_msg_id` bigint(20) unsigned DEFAULT NULL,
+  `creator_id` bigint(20) unsigned DEFAULT NULL,
+  `title` longtext,
+  PRIMARY KEY (`id`),
+  KEY `idx_thread_deleted_at` (`deleted_at`),
+  KEY `idx_thread_group_id` (`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_block` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  `deleted_at` datetime(3) DEFAULT NULL,
+  `user_id` bigint(20) unsigned DEFAULT NULL,
+  `blocked_id` bigint(20) unsigned DEFAULT NULL,
   PRIMARY KEY (`id`),
-  KEY `idx_message_deleted_at` (`deleted_at`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+  KEY `idx_user_block_deleted_at` (`deleted_at`),
+  KEY `idx_user_block_user_id` (`user_id`,`blocked_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `user_basic` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -14,11 +14,34 @@ func main() {
 	}
 
 	// 迁移 schema
-	db.AutoMigrate(&models.Community{})
+	db.AutoMigrate(&models.GroupBasic{})
 	//db.AutoMigrate(&models.UserBasic{})
-	//db.AutoMigrate(&models.Message{})
-	//db.AutoMigrate(&models.GroupBasic{})
+	db.AutoMigrate(&models.Message{})
+	// 聊天记录全文检索 ngram 分词支持中文
+	if !db.Migrator().HasIndex(&models.Message{}, "ft_message_content") {
+		db.Exec("ALTER TABLE message ADD FULLTEXT INDEX ft_message_content (content) WITH PARSER ngram")
+	}
 	//db.AutoMigrate(&models.Contact{})
+	db.AutoMigrate(&models.MessageHidden{})
+	db.AutoMigrate(&models.Thread{})
+	db.AutoMigrate(&models.Mention{})
+	db.AutoMigrate(&models.PinnedMessage{})
+	db.AutoMigrate(&models.StarredMessage{})
+	db.AutoMigrate(&models.ConversationSetting{})
+	db.AutoMigrate(&models.AccountDeletion{})
+	db.AutoMigrate(&models.FriendRequest{})
+	db.AutoMigrate(&models.UserBlock{})
+	db.AutoMigrate(&models.PrivacySetting{})
+	db.AutoMigrate(&models.ContactTag{})
+	db.AutoMigrate(&models.GroupMember{})
+	db.AutoMigrate(&models.GroupJoinRequest{})
+	db.AutoMigrate(&models.GroupAnnouncement{})
+	db.AutoMigrate(&models.AnnouncementAck{})
+	db.AutoMigrate(&models.GroupAuditLog{})
+	// 旧的 communities 表和群关系 contact type=2 迁移到 group_basic 和 group_member
+	if err := models.MigrateGroups(db); err != nil {
+		panic(err)
+	}
 
 	// Create
 	// user := &models.UserBasic{}